@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io/ioutil"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/golang/glog"
+	vault "github.com/hashicorp/vault/api"
 	"github.com/pkg/errors"
 	k8sCoreV1 "k8s.io/api/core/v1"
 	k8sApiErr "k8s.io/apimachinery/pkg/api/errors"
@@ -48,12 +51,50 @@ func (d *vtkData) approleAuthenticate() error {
 	return nil
 }
 
+// Authenticate in Vault without rotation and saving of credentials, used by dry-run, one sync and CLI subcommands
+func (d *vtkData) authenticateReadOnly() error {
+	switch authMethod {
+	case "approle":
+		// Wrapped token can be unwrapped only once, therefore 'secret_id' of running application is used
+		glog.Infoln("Authentication by AppRole with credentials from '" + appName + "-system' secret '" + podNamespace + "' namespace...")
+		err := d.approleReadAppSecret()
+		if k8sApiErr.IsNotFound(err) && once && dryRun != "true" {
+			// There is no running application, so one sync can use wrapped token
+			glog.Infoln("Application system secret doesn't exist, getting 'secret_id' from wrapped token")
+		} else if err != nil {
+			return errors.Wrap(err, "Error during read application system secret")
+		}
+		if err := d.approleGetToken(); err != nil {
+			return err
+		}
+	case "kubernetes":
+		glog.Infoln("Authentication by Kubernetes...")
+		if err := d.kubernetesGetToken(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Revoke token which was received by authenticateReadOnly
+func (d *vtkData) revokeReadOnlyToken() {
+	if authMethod == "token" || d.vaultTokenAccessor == "" {
+		return
+	}
+	if err := d.revokeToken(d.vaultTokenAccessor); err != nil {
+		glog.Errorln(err)
+	}
+}
+
 // Get token
 func (d *vtkData) approleGetToken() error {
 	// Get AppRole Secret ID from wrapped token
 	if d.approleSecretID == nil {
 		glog.Infoln("Getting 'secret_id' from wrapped token")
+		start := time.Now()
 		secretID, err := d.vaultClient.Logical().Unwrap(approleSecretIDWrappedToken)
+		observeAPIRequest(backendVault, "unwrap", start, err)
 		if err != nil {
 			if strings.Contains(err.Error(), "wrapping token is not valid or does not exist") {
 				return fmt.Errorf("There is no valid 'wrapped token' in 'APPROLE_SECRET_ID_WRAPPED_TOKEN' or 'APPROLE_SECRET_ID_WRAPPED_TOKEN_FILE'")
@@ -72,17 +113,77 @@ func (d *vtkData) approleGetToken() error {
 
 	// Fetching token
 	glog.V(2).Infoln("Fetching token from Vault")
+	start := time.Now()
+	vaultTokenValues, err := d.vaultClient.Logical().Write(authPath, options)
+	observeAPIRequest(backendVault, "login", start, err)
+	if err != nil {
+		return err
+	}
+	d.approleName = vaultTokenValues.Auth.Metadata["role_name"]
+	d.setToken(vaultTokenValues)
+
+	return nil
+}
+
+// Authenticate in Vault by Kubernetes auth method
+func (d *vtkData) kubernetesAuthenticate() error {
+	glog.Infoln("Authentication by Kubernetes...")
+	if err := d.kubernetesGetToken(); err != nil {
+		return err
+	}
+
+	glog.Infoln("Successfully authenticated")
+
+	return nil
+}
+
+// Get token by ServiceAccount JWT
+func (d *vtkData) kubernetesGetToken() error {
+	// Read JWT on each login as projected ServiceAccount token can be rotated by kubelet
+	jwt, err := ioutil.ReadFile(kubernetesTokenFile)
+	if err != nil {
+		return errors.Wrap(err, "Failed to read ServiceAccount token from file '"+kubernetesTokenFile+"'")
+	}
+
+	// Params for fetch token
+	options := map[string]interface{}{
+		"role": kubernetesRole,
+		"jwt":  strings.TrimSpace(string(jwt)),
+	}
+	authPath := fmt.Sprintf("auth/%s/login", kubernetesAuthPath)
+
+	// Fetching token
+	glog.V(2).Infoln("Fetching token from Vault")
+	start := time.Now()
 	vaultTokenValues, err := d.vaultClient.Logical().Write(authPath, options)
+	observeAPIRequest(backendVault, "login", start, err)
 	if err != nil {
 		return err
 	}
+	d.setToken(vaultTokenValues)
+
+	return nil
+}
+
+// Get token by configured auth method
+func (d *vtkData) getToken() error {
+	if authMethod == "kubernetes" {
+		return d.kubernetesGetToken()
+	}
+
+	return d.approleGetToken()
+}
+
+// Save token params and authenticate Vault client by it
+func (d *vtkData) setToken(vaultTokenValues *vault.Secret) {
 	// Get token params
 	vaultToken = vaultTokenValues.Auth.ClientToken
+	d.vaultTokenSecret = vaultTokenValues
 	d.vaultTokenAccessor = vaultTokenValues.Auth.Accessor
-	d.approleName = vaultTokenValues.Auth.Metadata["role_name"]
 	d.vaultTokenTTL = make(map[string]int64)
 	d.vaultTokenTTL["creation_time"] = time.Now().Unix()
 	d.vaultTokenTTL["ttl"] = int64(vaultTokenValues.Auth.LeaseDuration)
+	health.setTokenTTL(d.vaultTokenTTL["creation_time"], d.vaultTokenTTL["ttl"])
 	// Set correct value for token interval
 	if tokenRotationInterval == -1 {
 		tokenRotationInterval = int(float64(d.vaultTokenTTL["ttl"]) * 0.7)
@@ -94,18 +195,21 @@ func (d *vtkData) approleGetToken() error {
 
 	// Authenticate
 	d.vaultClient.SetToken(vaultToken)
-
-	return nil
 }
 
 // Read application system k8s secret
 func (d *vtkData) approleReadAppSecret() error {
 	secretName := appName + "-system"
 
+	start := time.Now()
 	appSecret, err := d.k8sClient.CoreV1().Secrets(podNamespace).Get(secretName, k8sMetaV1.GetOptions{})
+	observeAPIRequest(backendK8s, "get_secret", start, err)
 	if err != nil {
 		return err
 	}
+	if appSecret.Annotations["createdBy"] != appName {
+		return fmt.Errorf("Secret '%s' exists in '%s' namespace but it wasn't created by this application", secretName, podNamespace)
+	}
 	d.approleSecretID = string([]byte(appSecret.Data["approle_secret-id"]))
 
 	return nil
@@ -132,12 +236,17 @@ func (d *vtkData) updateAppSystemSecret() error {
 	secret.Annotations = annotations
 
 	// Read k8s application  secret
+	start := time.Now()
 	existing, err := d.k8sClient.CoreV1().Secrets(podNamespace).Get(secret.Name, k8sMetaV1.GetOptions{})
+	observeAPIRequest(backendK8s, "get_secret", start, err)
 
 	// Create new secret
 	if k8sApiErr.IsNotFound(err) {
 		glog.Infoln("Create application secret '" + secret.Name + "' in '" + podNamespace + "' namespace")
-		if _, err := d.k8sClient.CoreV1().Secrets(podNamespace).Create(secret); err != nil {
+		start = time.Now()
+		_, err = d.k8sClient.CoreV1().Secrets(podNamespace).Create(secret)
+		observeAPIRequest(backendK8s, "create_secret", start, err)
+		if err != nil {
 			return errors.Wrap(err, "Error during create application k8s secret")
 		}
 		return nil
@@ -155,7 +264,10 @@ func (d *vtkData) updateAppSystemSecret() error {
 
 	// Update application secret
 	glog.V(2).Infoln("Update application secret '" + secret.Name + "' in '" + podNamespace + "' namespace")
-	if _, err = d.k8sClient.CoreV1().Secrets(podNamespace).Update(secret); err != nil {
+	start = time.Now()
+	_, err = d.k8sClient.CoreV1().Secrets(podNamespace).Update(secret)
+	observeAPIRequest(backendK8s, "update_secret", start, err)
+	if err != nil {
 		return errors.Wrap(err, "Error during update k8s secret")
 	}
 
@@ -167,7 +279,9 @@ func (d *vtkData) approleRevokeOldSecretID() error {
 	secretName := appName + "-system"
 
 	glog.V(2).Infoln("Read 'approle_secret-id' from '" + secretName + "' secret")
+	start := time.Now()
 	appSecret, err := d.k8sClient.CoreV1().Secrets(podNamespace).Get(secretName, k8sMetaV1.GetOptions{})
+	observeAPIRequest(backendK8s, "get_secret", start, err)
 	if err != nil {
 		return err
 	}
@@ -179,7 +293,9 @@ func (d *vtkData) approleRevokeOldSecretID() error {
 	lookupAuthPath := fmt.Sprintf("auth/%s/role/%s/secret-id/lookup", authMethod, d.approleName)
 
 	// Get 'secret_id_accessor' for 'secret_id'
+	start = time.Now()
 	lookupSecretID, err := d.vaultClient.Logical().Write(lookupAuthPath, optionsLookupSecretID)
+	observeAPIRequest(backendVault, "lookup_secret_id", start, err)
 	if err != nil {
 		return err
 	}
@@ -196,7 +312,9 @@ func (d *vtkData) approleRevokeOldSecretID() error {
 
 	// Revoking old 'secret_id' by 'secret_id_accessor' (to revoke both)
 	glog.V(2).Infoln("Revoking old AppRole Secret ID from '" + secretName + "' secret")
+	start = time.Now()
 	_, err = d.vaultClient.Logical().Write(destroyAuthPath, optionsDestroySecretID)
+	observeAPIRequest(backendVault, "destroy_secret_id", start, err)
 	if err != nil {
 		return err
 	}
@@ -209,7 +327,9 @@ func (d *vtkData) revokeOldToken() error {
 	secretName := appName + "-system"
 
 	glog.V(2).Infoln("Read 'token-accessor' from '" + secretName + "' secret")
+	start := time.Now()
 	appSecret, err := d.k8sClient.CoreV1().Secrets(podNamespace).Get(secretName, k8sMetaV1.GetOptions{})
+	observeAPIRequest(backendK8s, "get_secret", start, err)
 	if err != nil {
 		return err
 	}
@@ -219,6 +339,12 @@ func (d *vtkData) revokeOldToken() error {
 		return nil
 	}
 
+	glog.V(2).Infoln("Revoking old 'token' by 'token_accessor' from '" + secretName + "' secret")
+	return d.revokeToken(vaultTokenAccessor)
+}
+
+// Revoke Token by Token Accessor
+func (d *vtkData) revokeToken(vaultTokenAccessor string) error {
 	// Params for 'token' revoke
 	optionsDestroyToken := map[string]interface{}{
 		"accessor": vaultTokenAccessor,
@@ -226,11 +352,12 @@ func (d *vtkData) revokeOldToken() error {
 	revokeAuthPath := fmt.Sprintf("auth/token/revoke-accessor")
 
 	// Revoking old 'token' by 'token_accessor' (to revoke both)
-	glog.V(2).Infoln("Revoking old 'token' by 'token_accessor' from '" + secretName + "' secret")
-	_, err = d.vaultClient.Logical().Write(revokeAuthPath, optionsDestroyToken)
+	start := time.Now()
+	_, err := d.vaultClient.Logical().Write(revokeAuthPath, optionsDestroyToken)
+	observeAPIRequest(backendVault, "revoke_token", start, err)
 	if err != nil {
 		if strings.Contains(err.Error(), "invalid accessor") {
-			glog.V(2).Infoln("There is no valid 'token-accessor' in '" + secretName + "' secret for revoke 'token'")
+			glog.V(2).Infoln("There is no valid 'token-accessor' for revoke 'token'")
 		} else {
 			return err
 		}
@@ -248,7 +375,9 @@ func (d *vtkData) approleSecretIDLookup() error {
 	lookupAuthPath := fmt.Sprintf("auth/%s/role/%s/secret-id/lookup", authMethod, d.approleName)
 
 	// Get AppRole SecretID params
+	start := time.Now()
 	lookupSecretID, err := d.vaultClient.Logical().Write(lookupAuthPath, optionsLookupSecretID)
+	observeAPIRequest(backendVault, "lookup_secret_id", start, err)
 	if err != nil {
 		return err
 	}
@@ -260,12 +389,13 @@ func (d *vtkData) approleSecretIDLookup() error {
 	secretIDTTL, _ := strconv.ParseInt(fmt.Sprintf("%s", lookupSecretID.Data["secret_id_ttl"]), 10, 64)
 	d.approleSecretIDTTL["creation_time"] = expirationTime.Unix()
 	d.approleSecretIDTTL["secret_id_ttl"] = secretIDTTL
+	health.setSecretIDTTL(d.approleSecretIDTTL["creation_time"], secretIDTTL)
 
 	return nil
 }
 
 // AppRole Secret ID rotation
-func (d *vtkData) approleSecretIDRotation() {
+func (d *vtkData) approleSecretIDRotation(ctx context.Context) {
 	approleSecretIDLookupRetry := 60
 	glog.Infoln("AppRole Secret ID rotation enabled")
 
@@ -275,7 +405,11 @@ func (d *vtkData) approleSecretIDRotation() {
 			glog.Errorln("AppRole Secret ID rotation wasn't enabled due to error during getting 'creation_time' for 'secret_id'")
 			glog.Errorln("Next retry will be in '" + strconv.Itoa(approleSecretIDLookupRetry) + "' seconds")
 			authApproleSecretID.WithLabelValues("rotation-status").Set(0)
-			time.Sleep(time.Duration(approleSecretIDLookupRetry) * time.Second)
+			health.setSecretIDRotationFailed(true)
+			if !sleepContext(ctx, time.Duration(approleSecretIDLookupRetry)*time.Second) {
+				glog.Infoln("AppRole Secret ID rotation stopped")
+				return
+			}
 			approleSecretIDLookupRetry = approleSecretIDLookupRetry * 2
 		} else {
 			approleSecretIDLookupRetry = 60
@@ -293,8 +427,10 @@ func (d *vtkData) approleSecretIDRotation() {
 			timeWaitBeforeRotation := approleSecretIDRotationInterval - int(time.Now().Unix()-d.approleSecretIDTTL["creation_time"])
 			glog.V(2).Infoln("Secret ID will be rotated in '" + strconv.Itoa(timeWaitBeforeRotation) + "' seconds")
 			authApproleSecretID.WithLabelValues("next-rotation-timestamp").Set(float64(time.Now().Unix() + int64(timeWaitBeforeRotation)))
-			timer := time.NewTimer(time.Duration(timeWaitBeforeRotation) * time.Second)
-			<-timer.C
+			if !sleepContext(ctx, time.Duration(timeWaitBeforeRotation)*time.Second) {
+				glog.Infoln("AppRole Secret ID rotation stopped")
+				return
+			}
 			for {
 				glog.V(2).Infoln("Rotating Secret ID...")
 				// Params for creating new 'secret_id'
@@ -302,12 +438,18 @@ func (d *vtkData) approleSecretIDRotation() {
 				authPath := fmt.Sprintf("auth/%s/role/%s/secret-id", authMethod, d.approleName)
 
 				glog.V(2).Infoln("Generating new Secret ID")
+				start := time.Now()
 				approleSecretID, err := d.vaultClient.Logical().Write(authPath, options)
+				observeAPIRequest(backendVault, "generate_secret_id", start, err)
 				if err != nil {
 					glog.Errorln(err)
 					glog.Errorln("Waiting 60 seconds before retry of generating new Secret ID")
 					authApproleSecretID.WithLabelValues("last-rotation-status").Set(0)
-					time.Sleep(60 * time.Second)
+					health.setSecretIDRotationFailed(true)
+					if !sleepContext(ctx, 60*time.Second) {
+						glog.Infoln("AppRole Secret ID rotation stopped")
+						return
+					}
 				} else {
 					d.approleSecretID = approleSecretID.Data["secret_id"]
 					// Revoke old 'secret_id' before replace it by new one
@@ -322,11 +464,16 @@ func (d *vtkData) approleSecretIDRotation() {
 						glog.Errorln(err)
 						glog.Errorln("Waiting 60 seconds before retry of generating new Secret ID")
 						authApproleSecretID.WithLabelValues("last-rotation-status").Set(0)
-						time.Sleep(60 * time.Second)
+						health.setSecretIDRotationFailed(true)
+						if !sleepContext(ctx, 60*time.Second) {
+							glog.Infoln("AppRole Secret ID rotation stopped")
+							return
+						}
 						continue
 					}
 					glog.V(2).Infoln("Secret ID successfully rotated")
 					authApproleSecretID.WithLabelValues("last-rotation-status").Set(1)
+					health.setSecretIDRotationFailed(false)
 					break
 				}
 			}
@@ -334,38 +481,120 @@ func (d *vtkData) approleSecretIDRotation() {
 	}
 }
 
-// Token rotation
-func (d *vtkData) tokenRotation() {
+// Check if token can be renewed instead of rotation
+func (d *vtkData) tokenRenewable() bool {
+	return tokenRenewal == "true" && d.vaultTokenSecret != nil && d.vaultTokenSecret.Auth != nil && d.vaultTokenSecret.Auth.Renewable
+}
+
+// Renew token by Vault lifetime watcher until it reaches max TTL or renewal fails, returns 'false' if application is stopping and error of renewal
+func (d *vtkData) tokenRenewalWatch(ctx context.Context) (bool, error) {
+	watcher, err := d.vaultClient.NewLifetimeWatcher(&vault.LifetimeWatcherInput{
+		Secret:        d.vaultTokenSecret,
+		RenewBehavior: vault.RenewBehaviorErrorOnErrors,
+	})
+	if err != nil {
+		authToken.WithLabelValues("last-renewal-status").Set(0)
+		return true, errors.Wrap(err, "Error during start of token renewal")
+	}
+	go watcher.Start()
+	defer watcher.Stop()
+
+	glog.V(2).Infoln("Token will be renewed until it reaches max TTL")
+	for {
+		select {
+		case <-ctx.Done():
+			return false, nil
+		case err := <-watcher.DoneCh():
+			if err != nil {
+				authToken.WithLabelValues("last-renewal-status").Set(0)
+				return true, errors.Wrap(err, "Error during renew token")
+			}
+			glog.V(2).Infoln("Token can't be renewed anymore as it reaches max TTL")
+			return true, nil
+		case renewal := <-watcher.RenewCh():
+			glog.V(2).Infoln("Token successfully renewed")
+			// Renewed token is replaced by new one not later than it expires
+			d.vaultTokenTTL["creation_time"] = renewal.RenewedAt.Unix()
+			if renewal.Secret != nil && renewal.Secret.Auth != nil {
+				d.vaultTokenTTL["ttl"] = int64(renewal.Secret.Auth.LeaseDuration)
+			}
+			health.setTokenTTL(d.vaultTokenTTL["creation_time"], d.vaultTokenTTL["ttl"])
+			health.setTokenRotationFailed(false)
+			authToken.WithLabelValues("next-rotation-timestamp").Set(float64(d.vaultTokenTTL["creation_time"] + d.vaultTokenTTL["ttl"]))
+			authToken.WithLabelValues("last-renewal-status").Set(1)
+			authToken.WithLabelValues("last-renewal-timestamp").Set(float64(renewal.RenewedAt.Unix()))
+		}
+	}
+}
+
+// Token rotation, renewable token is renewed in place and rotated only when it can't be renewed anymore, in dry-run token is replaced by new read-only one
+func (d *vtkData) tokenRotation(ctx context.Context) {
 	glog.Infoln("Token rotation enabled")
+	// New token after failed renewal is rotated by interval, so it doesn't cause loop of logins if renewal isn't allowed
+	renewalFailed := false
 	for {
-		timeWaitBeforeRotation := tokenRotationInterval - int(time.Now().Unix()-d.vaultTokenTTL["creation_time"])
-		glog.V(2).Infoln("Token will be rotated in '" + strconv.Itoa(timeWaitBeforeRotation) + "' seconds")
-		authToken.WithLabelValues("next-rotation-timestamp").Set(float64(time.Now().Unix() + int64(timeWaitBeforeRotation)))
-		timer := time.NewTimer(time.Duration(timeWaitBeforeRotation) * time.Second)
-		<-timer.C
+		if d.tokenRenewable() && !renewalFailed {
+			running, err := d.tokenRenewalWatch(ctx)
+			if !running {
+				glog.Infoln("Token rotation stopped")
+				return
+			}
+			if err != nil {
+				glog.Errorln(err)
+				renewalFailed = true
+			}
+			glog.V(2).Infoln("Token will be replaced by new one")
+		} else {
+			renewalFailed = false
+			timeWaitBeforeRotation := tokenRotationInterval - int(time.Now().Unix()-d.vaultTokenTTL["creation_time"])
+			glog.V(2).Infoln("Token will be rotated in '" + strconv.Itoa(timeWaitBeforeRotation) + "' seconds")
+			authToken.WithLabelValues("next-rotation-timestamp").Set(float64(time.Now().Unix() + int64(timeWaitBeforeRotation)))
+			if !sleepContext(ctx, time.Duration(timeWaitBeforeRotation)*time.Second) {
+				glog.Infoln("Token rotation stopped")
+				return
+			}
+		}
 		glog.V(2).Infoln("Rotating Token...")
-		if err := d.approleGetToken(); err != nil {
+		oldVaultTokenAccessor := d.vaultTokenAccessor
+		getToken := d.getToken
+		if dryRun == "true" {
+			getToken = d.authenticateReadOnly
+		}
+		if err := getToken(); err != nil {
 			glog.Errorln(err)
 			glog.Errorln("Waiting 60 seconds before retry generating new token")
 			authToken.WithLabelValues("last-rotation-status").Set(0)
-			time.Sleep(60 * time.Second)
+			health.setTokenRotationFailed(true)
+			if !sleepContext(ctx, 60*time.Second) {
+				glog.Infoln("Token rotation stopped")
+				return
+			}
 		} else {
-			// Revoke old 'token' before replace it by new one
-			if err := d.revokeOldToken(); err != nil {
+			// Revoke old 'token' before replace it by new one, token of running application is saved in system secret
+			var err error
+			if authMethod == "kubernetes" || dryRun == "true" {
+				err = d.revokeToken(oldVaultTokenAccessor)
+			} else {
+				err = d.revokeOldToken()
+			}
+			if err != nil {
 				glog.Errorln(err)
 				authToken.WithLabelValues("error-revoke-token").Set(1)
 			} else {
 				authToken.WithLabelValues("error-revoke-token").Set(0)
 			}
 			// Save 'token_accessor' to k8s secret object
-			if err := d.updateAppSystemSecret(); err != nil {
-				glog.Errorln(err)
-				authToken.WithLabelValues("error-save-token-accessor-in-k8s-secret").Set(1)
-			} else {
-				authToken.WithLabelValues("error-save-token-accessor-in-k8s-secret").Set(0)
+			if authMethod == "approle" && dryRun != "true" {
+				if err := d.updateAppSystemSecret(); err != nil {
+					glog.Errorln(err)
+					authToken.WithLabelValues("error-save-token-accessor-in-k8s-secret").Set(1)
+				} else {
+					authToken.WithLabelValues("error-save-token-accessor-in-k8s-secret").Set(0)
+				}
 			}
 			glog.V(2).Infoln("Token successfully rotated")
 			authToken.WithLabelValues("last-rotation-status").Set(1)
+			health.setTokenRotationFailed(false)
 		}
 	}
 }
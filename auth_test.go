@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"strings"
 	"testing"
+	"time"
 
 	k8sMetaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -362,3 +366,203 @@ func TestApproleSecretIDLookup(t *testing.T) {
 		t.Fatalf("Incorrect value for 'secret_id_ttl': '%d'. Expected '%d'", d.approleSecretIDTTL["secret_id_ttl"], tvsAppRoleSecretIDTTL)
 	}
 }
+
+// Test get token by Kubernetes auth method without ServiceAccount token file
+func TestKubernetesGetTokenNoTokenFile(t *testing.T) {
+	d := &vtkData{}
+	tvsd := d.testVaultServer(t)
+	defer tvsd.server.Close()
+
+	kubernetesTokenFile = "/nonexistent/serviceaccount/token"
+	defer func() { kubernetesTokenFile = "" }()
+
+	err := d.kubernetesGetToken()
+	if err == nil {
+		t.Fatal("Expected error, but it wasn't returned")
+	}
+
+	if !strings.Contains(err.Error(), "Failed to read ServiceAccount token from file") {
+		t.Log(err)
+		t.Fatal("Incorrect error response")
+	}
+}
+
+// Test get token by Kubernetes auth method when auth method isn't enabled in Vault
+func TestKubernetesGetTokenAuthNotEnabled(t *testing.T) {
+	d := &vtkData{}
+	tvsd := d.testVaultServer(t)
+	defer tvsd.server.Close()
+
+	tokenFile, err := ioutil.TempFile("", "vtk-sa-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tokenFile.Name())
+	if _, err := tokenFile.WriteString("fake.jwt.token\n"); err != nil {
+		t.Fatal(err)
+	}
+	tokenFile.Close()
+
+	kubernetesTokenFile = tokenFile.Name()
+	kubernetesRole = "vault-to-k8s"
+	kubernetesAuthPath = "kubernetes"
+	defer func() { kubernetesTokenFile, kubernetesRole, kubernetesAuthPath = "", "", "" }()
+
+	err = d.kubernetesGetToken()
+	if err == nil {
+		t.Fatal("Expected error, but it wasn't returned")
+	}
+
+	if !strings.Contains(err.Error(), "auth/kubernetes/login") {
+		t.Log(err)
+		t.Fatal("Incorrect error response")
+	}
+}
+
+// Test renewal of token and its fallback to rotation if renewal fails
+func TestTokenRenewalWatch(t *testing.T) {
+	d := &vtkData{}
+	tvsd := d.testVaultServer(t)
+	defer tvsd.server.Close()
+	d.testVaultServerCreateWrappedSecretID(t)
+	defer defineAppInitParams()
+
+	if err := d.approleGetToken(); err != nil {
+		t.Fatal(err)
+	}
+	if !d.tokenRenewable() {
+		t.Fatal("AppRole token should be renewable")
+	}
+
+	// Token is renewed in place until application is stopping
+	d.vaultTokenTTL["creation_time"] = 0
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	running, err := d.tokenRenewalWatch(ctx)
+	if running || err != nil {
+		t.Fatalf("Renewal should be stopped without error, got '%v', '%v'", running, err)
+	}
+	if status := readMetricValue(authToken.WithLabelValues("last-renewal-status")); status != 1 {
+		t.Fatalf("Expected successful renewal status, got '%v'", status)
+	}
+	if d.vaultTokenTTL["creation_time"] == 0 {
+		t.Fatal("Time of token renewal should be saved")
+	}
+	expiration := d.vaultTokenTTL["creation_time"] + d.vaultTokenTTL["ttl"]
+	if next := readMetricValue(authToken.WithLabelValues("next-rotation-timestamp")); next != float64(expiration) {
+		t.Fatalf("Expected next rotation of renewed token at '%d', got '%v'", expiration, next)
+	}
+	if health.tokenExpiration.Unix() != expiration {
+		t.Fatalf("Expected expiration of renewed token '%d' in health state, got '%d'", expiration, health.tokenExpiration.Unix())
+	}
+
+	// Error of renewal is returned, so token is rotated by new login
+	if err := d.vaultClient.Auth().Token().RevokeSelf(""); err != nil {
+		t.Fatal(err)
+	}
+	running, err = d.tokenRenewalWatch(context.Background())
+	if !running || err == nil {
+		t.Fatalf("Renewal of revoked token should fail, got '%v', '%v'", running, err)
+	}
+	if status := readMetricValue(authToken.WithLabelValues("last-renewal-status")); status != 0 {
+		t.Fatalf("Expected unsuccessful renewal status, got '%v'", status)
+	}
+
+	// Token is only rotated if renewal is disabled
+	tokenRenewal = "false"
+	if d.tokenRenewable() {
+		t.Fatal("Token shouldn't be renewed if renewal is disabled")
+	}
+}
+
+// Test token rotation in dry-run replaces only own read-only token
+func TestTokenRotationDryRun(t *testing.T) {
+	d := &vtkData{}
+	tvsd := d.testVaultServer(t)
+	defer tvsd.server.Close()
+	tksd := d.testK8sServer(t)
+	defer defineAppInitParams()
+	dryRun = "true"
+	tokenRenewal = "false"
+
+	// Credentials of running application
+	rootToken := d.vaultClient.Token()
+	d.testVaultServerFetchToken(t)
+	tksd.systemSecretTokenAccessor = d.vaultTokenAccessor
+	tksd.systemSecretAppRoleSecretID = fmt.Sprintf("%s", d.approleSecretID)
+	d.testK8sServerCreateSystemSecret(t, tksd, "none")
+	d.approleSecretID = nil
+
+	if err := d.authenticateReadOnly(); err != nil {
+		t.Fatal(err)
+	}
+	readOnlyTokenAccessor := d.vaultTokenAccessor
+	tokenRotationInterval = 1
+	ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+	defer cancel()
+	d.tokenRotation(ctx)
+
+	if d.vaultTokenAccessor == readOnlyTokenAccessor {
+		t.Fatal("Token of dry run should be replaced by new one")
+	}
+	if _, err := d.vaultClient.Auth().Token().LookupSelf(); err != nil {
+		t.Fatal("New token of dry run should be valid:", err)
+	}
+	appSecret, err := d.k8sClient.CoreV1().Secrets(podNamespace).Get(appName+"-system", k8sMetaV1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(appSecret.Data["token-accessor"]) != tksd.systemSecretTokenAccessor {
+		t.Fatal("Application system secret shouldn't be updated in dry-run")
+	}
+
+	// Previous token of dry run is revoked, token of running application isn't revoked
+	d.vaultClient.SetToken(rootToken)
+	if _, err := d.vaultClient.Auth().Token().LookupAccessor(readOnlyTokenAccessor); err == nil {
+		t.Fatal("Previous token of dry run should be revoked")
+	}
+	if _, err := d.vaultClient.Auth().Token().LookupAccessor(tksd.systemSecretTokenAccessor); err != nil {
+		t.Fatal("Token of running application shouldn't be revoked:", err)
+	}
+}
+
+// Test authentication for one sync doesn't create application system secret and doesn't use secret of another application
+func TestAuthenticateReadOnlyOnce(t *testing.T) {
+	d := &vtkData{}
+	tvsd := d.testVaultServer(t)
+	defer tvsd.server.Close()
+	tksd := d.testK8sServer(t)
+	defer defineAppInitParams()
+	once = true
+
+	// Without running application 'secret_id' is got from wrapped token
+	d.testVaultServerCreateWrappedSecretID(t)
+	if err := d.authenticateReadOnly(); err != nil {
+		t.Log(err)
+		t.Fatal("Error should not be raised")
+	}
+	if _, err := d.vaultClient.Auth().Token().LookupSelf(); err != nil {
+		t.Fatal("Token of one sync should be valid:", err)
+	}
+	if _, err := d.k8sClient.CoreV1().Secrets(podNamespace).Get(appName+"-system", k8sMetaV1.GetOptions{}); err == nil {
+		t.Fatal("Application system secret shouldn't be created by one sync")
+	}
+
+	// Token is revoked on exit
+	d.revokeReadOnlyToken()
+	if _, err := d.vaultClient.Auth().Token().LookupSelf(); err == nil {
+		t.Fatal("Token of one sync should be revoked")
+	}
+
+	// System secret of another application isn't used
+	d.approleSecretID = nil
+	d.testK8sServerCreateSystemSecret(t, tksd, "IncorrectAnnotation")
+	err := d.authenticateReadOnly()
+	if err == nil {
+		t.Fatal("Expected error, but it wasn't returned")
+	}
+	if !strings.Contains(err.Error(), "it wasn't created by this application") {
+		t.Log(err)
+		t.Fatal("Incorrect error response")
+	}
+}
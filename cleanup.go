@@ -0,0 +1,314 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	k8sCoreV1 "k8s.io/api/core/v1"
+	k8sApiErr "k8s.io/apimachinery/pkg/api/errors"
+	k8sMetaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Prune managed k8s secrets which source was deleted from Vault
+func (d *vtkData) pruneSecretsInK8s(vaultDir, namespace string, vaultSecrets, vaultDeletedSecrets []string) (float64, error) {
+	pruned := 0.0
+
+	// Empty list for namespace which exists in Vault is suspicious, don't prune anything
+	if len(vaultSecrets) == 0 {
+		glog.Warningln("Didn't get any secrets from Vault for '" + namespace + "' namespace, prune skipped")
+		return pruned, nil
+	}
+
+	// Vault secrets which can be a source of k8s secrets
+	vaultSecretsPathNamespace := d.vaultSecretsPath() + "/" + vaultDir + "/"
+	vaultSecretPaths := make(map[string]bool)
+	for _, vaultSecret := range vaultSecrets {
+		vaultSecretPaths[vaultSecretsPathNamespace+vaultSecret] = true
+	}
+	for _, vaultDeletedSecret := range vaultDeletedSecrets {
+		delete(vaultSecretPaths, vaultDeletedSecret)
+	}
+
+	managedSecrets, err := d.k8sManagedSecretsList(namespace)
+	if err != nil {
+		return pruned, err
+	}
+
+	if d.pruneCandidates == nil {
+		d.pruneCandidates = make(map[string]int64)
+	}
+	now := time.Now().Unix()
+	orphanedSecrets := make(map[string]bool)
+	secretsForPrune := []string{}
+	// All versions of deleted Vault secret are counted once against PRUNE_MAX_PER_NAMESPACE
+	vaultPathsForPrune := make(map[string]bool)
+	for _, secret := range managedSecrets {
+		secretPath := secret.Annotations[d.annotationName()]
+		if !strings.HasPrefix(secretPath, vaultSecretsPathNamespace) || vaultSecretPaths[secretPath] {
+			continue
+		}
+
+		candidate := namespace + "/" + secret.Name
+		orphanedSecrets[candidate] = true
+		if _, ok := d.pruneCandidates[candidate]; !ok {
+			glog.V(2).Infoln("Source '" + secretPath + "' of k8s secret '" + secret.Name + "' in '" + namespace + "' namespace was deleted from Vault, secret will be pruned in '" + strconv.Itoa(pruneGracePeriod) + "' seconds")
+			d.pruneCandidates[candidate] = now
+		}
+		if now-d.pruneCandidates[candidate] >= int64(pruneGracePeriod) {
+			secretsForPrune = append(secretsForPrune, secret.Name)
+			vaultPathsForPrune[secretPath] = true
+		}
+	}
+
+	// Forget secrets which source appeared in Vault again
+	for candidate := range d.pruneCandidates {
+		if strings.HasPrefix(candidate, namespace+"/") && !orphanedSecrets[candidate] {
+			delete(d.pruneCandidates, candidate)
+		}
+	}
+
+	if len(vaultPathsForPrune) > pruneMaxPerNamespace {
+		return pruned, fmt.Errorf("Refusing to prune '%d' secrets of '%d' deleted Vault secrets in '%s' namespace as it's more than 'PRUNE_MAX_PER_NAMESPACE' (%d)", len(secretsForPrune), len(vaultPathsForPrune), namespace, pruneMaxPerNamespace)
+	}
+
+	for _, secretName := range secretsForPrune {
+		glog.V(2).Infoln("Prune k8s secret '" + secretName + "' in '" + namespace + "' namespace")
+		start := time.Now()
+		err := d.k8sClient.CoreV1().Secrets(namespace).Delete(secretName, &k8sMetaV1.DeleteOptions{})
+		observeAPIRequest(backendK8s, "delete_secret", start, err)
+		if err != nil && !k8sApiErr.IsNotFound(err) {
+			return pruned, errors.Wrap(err, "Error during prune k8s secret")
+		}
+		delete(d.pruneCandidates, namespace+"/"+secretName)
+		pruned++
+	}
+
+	return pruned, nil
+}
+
+// Number of secret versions to keep in namespace
+func (d *vtkData) versionsRetention(namespace string) int {
+	if retention, ok := d.versionsRetentionNamespaces[namespace]; ok {
+		return retention
+	}
+
+	return versionsRetention
+}
+
+// Delete superseded versions of managed k8s secrets
+func (d *vtkData) deleteOldSecretVersionsInK8s(vaultDir, namespace string) (float64, error) {
+	deleted := 0.0
+	retention := d.versionsRetention(namespace)
+	// Current version is never garbage collected
+	if versionsGC == "true" && retention == 0 {
+		retention = 1
+	}
+
+	managedSecrets, err := d.k8sManagedSecretsList(namespace)
+	if err != nil {
+		return deleted, err
+	}
+
+	// Get secrets used by workloads
+	var referencedSecrets map[string]bool
+	if versionsGC == "true" {
+		referencedSecrets, err = d.k8sReferencedSecrets(namespace)
+		if err != nil {
+			return deleted, errors.Wrap(err, "Error during get k8s secrets referenced by workloads")
+		}
+		if d.unreferencedSecrets == nil {
+			d.unreferencedSecrets = make(map[string]int64)
+		}
+	}
+	now := time.Now().Unix()
+	gcCandidates := make(map[string]bool)
+
+	// Group versions of k8s secrets by Vault secret
+	vaultSecretsPathNamespace := d.vaultSecretsPath() + "/" + vaultDir + "/"
+	secretVersions := make(map[string][]int)
+	for _, secret := range managedSecrets {
+		secretPath := secret.Annotations[d.annotationName()]
+		if !strings.HasPrefix(secretPath, vaultSecretsPathNamespace) {
+			continue
+		}
+		versionPrefix := k8sSecretName(strings.TrimPrefix(secretPath, vaultSecretsPathNamespace)) + "-v"
+		if !strings.HasPrefix(secret.Name, versionPrefix) {
+			continue
+		}
+		version, err := strconv.Atoi(strings.TrimPrefix(secret.Name, versionPrefix))
+		if err != nil {
+			continue
+		}
+		secretVersions[versionPrefix] = append(secretVersions[versionPrefix], version)
+	}
+
+	failed := 0
+	for versionPrefix, versions := range secretVersions {
+		if len(versions) <= retention {
+			continue
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+		for _, version := range versions[retention:] {
+			secretName := versionPrefix + strconv.Itoa(version)
+
+			// Keep version while it's used by workloads
+			if versionsGC == "true" {
+				candidate := namespace + "/" + secretName
+				if referencedSecrets[secretName] {
+					glog.V(2).Infoln("Keep old version k8s secret '" + secretName + "' in '" + namespace + "' namespace as it's referenced by workloads")
+					continue
+				}
+				gcCandidates[candidate] = true
+				if _, ok := d.unreferencedSecrets[candidate]; !ok {
+					d.unreferencedSecrets[candidate] = now
+				}
+				if now-d.unreferencedSecrets[candidate] < int64(versionsGCPeriod) {
+					continue
+				}
+			}
+
+			glog.V(2).Infoln("Delete old version k8s secret '" + secretName + "' in '" + namespace + "' namespace")
+			start := time.Now()
+			err := d.k8sClient.CoreV1().Secrets(namespace).Delete(secretName, &k8sMetaV1.DeleteOptions{})
+			observeAPIRequest(backendK8s, "delete_secret", start, err)
+			if err != nil && !k8sApiErr.IsNotFound(err) {
+				// Keep going with other versions, failed one is retried in the next cycle
+				glog.Errorln(errors.Wrap(err, "Error during delete old version k8s secret '"+secretName+"' in '"+namespace+"' namespace"))
+				failed++
+				continue
+			}
+			delete(d.unreferencedSecrets, namespace+"/"+secretName)
+			deleted++
+		}
+	}
+
+	// Forget versions which are referenced again or don't exist anymore
+	for candidate := range d.unreferencedSecrets {
+		if strings.HasPrefix(candidate, namespace+"/") && !gcCandidates[candidate] {
+			delete(d.unreferencedSecrets, candidate)
+		}
+	}
+
+	if failed > 0 {
+		return deleted, fmt.Errorf("Failed to delete '%d' old version(s) of k8s secrets in '%s' namespace", failed, namespace)
+	}
+	return deleted, nil
+}
+
+// List of k8s secrets referenced by workloads in namespace
+func (d *vtkData) k8sReferencedSecrets(namespace string) (map[string]bool, error) {
+	referencedSecrets := make(map[string]bool)
+
+	start := time.Now()
+	pods, err := d.k8sClient.CoreV1().Pods(namespace).List(k8sMetaV1.ListOptions{})
+	observeAPIRequest(backendK8s, "list_pods", start, err)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range pods.Items {
+		podSpecSecrets(&v.Spec, referencedSecrets)
+	}
+
+	start = time.Now()
+	deployments, err := d.k8sClient.AppsV1().Deployments(namespace).List(k8sMetaV1.ListOptions{})
+	observeAPIRequest(backendK8s, "list_deployments", start, err)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range deployments.Items {
+		podSpecSecrets(&v.Spec.Template.Spec, referencedSecrets)
+	}
+
+	// Old ReplicaSets of Deployment are scaled to zero, but they are used by 'kubectl rollout undo'
+	start = time.Now()
+	replicaSets, err := d.k8sClient.AppsV1().ReplicaSets(namespace).List(k8sMetaV1.ListOptions{})
+	observeAPIRequest(backendK8s, "list_replicasets", start, err)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range replicaSets.Items {
+		podSpecSecrets(&v.Spec.Template.Spec, referencedSecrets)
+	}
+
+	start = time.Now()
+	statefulSets, err := d.k8sClient.AppsV1().StatefulSets(namespace).List(k8sMetaV1.ListOptions{})
+	observeAPIRequest(backendK8s, "list_statefulsets", start, err)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range statefulSets.Items {
+		podSpecSecrets(&v.Spec.Template.Spec, referencedSecrets)
+	}
+
+	start = time.Now()
+	daemonSets, err := d.k8sClient.AppsV1().DaemonSets(namespace).List(k8sMetaV1.ListOptions{})
+	observeAPIRequest(backendK8s, "list_daemonsets", start, err)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range daemonSets.Items {
+		podSpecSecrets(&v.Spec.Template.Spec, referencedSecrets)
+	}
+
+	start = time.Now()
+	jobs, err := d.k8sClient.BatchV1().Jobs(namespace).List(k8sMetaV1.ListOptions{})
+	observeAPIRequest(backendK8s, "list_jobs", start, err)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range jobs.Items {
+		podSpecSecrets(&v.Spec.Template.Spec, referencedSecrets)
+	}
+
+	start = time.Now()
+	cronJobs, err := d.k8sClient.BatchV1beta1().CronJobs(namespace).List(k8sMetaV1.ListOptions{})
+	observeAPIRequest(backendK8s, "list_cronjobs", start, err)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range cronJobs.Items {
+		podSpecSecrets(&v.Spec.JobTemplate.Spec.Template.Spec, referencedSecrets)
+	}
+
+	return referencedSecrets, nil
+}
+
+// Collect names of k8s secrets used in pod spec
+func podSpecSecrets(spec *k8sCoreV1.PodSpec, secrets map[string]bool) {
+	for _, volume := range spec.Volumes {
+		if volume.Secret != nil {
+			secrets[volume.Secret.SecretName] = true
+		}
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if source.Secret != nil {
+					secrets[source.Secret.Name] = true
+				}
+			}
+		}
+	}
+
+	for _, imagePullSecret := range spec.ImagePullSecrets {
+		secrets[imagePullSecret.Name] = true
+	}
+
+	containers := append([]k8sCoreV1.Container{}, spec.InitContainers...)
+	containers = append(containers, spec.Containers...)
+	for _, container := range containers {
+		for _, env := range container.Env {
+			if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil {
+				secrets[env.ValueFrom.SecretKeyRef.Name] = true
+			}
+		}
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.SecretRef != nil {
+				secrets[envFrom.SecretRef.Name] = true
+			}
+		}
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	k8sAppsV1 "k8s.io/api/apps/v1"
+	k8sCoreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8sTesting "k8s.io/client-go/testing"
+)
+
+// Test prune managed k8s secrets which source was deleted from Vault
+func TestPruneSecretsInK8s(t *testing.T) {
+	d := &vtkData{}
+	_ = d.testK8sServer(t)
+	pruneGracePeriod = 0
+	pruneMaxPerNamespace = 10
+
+	vaultSecrets := []string{"secret1", "secret2"}
+	vaultSecretsPathNamespace := vaultSecretsPath + "/k8s-ns1/"
+	// Managed secret with source in Vault
+	d.testK8sServerCreateSecret(t, "secret1-v1", "k8s-ns1", annotationName, vaultSecretsPathNamespace+"secret1")
+	// Managed secret which source was soft-deleted in Vault
+	d.testK8sServerCreateSecret(t, "secret2-v1", "k8s-ns1", annotationName, vaultSecretsPathNamespace+"secret2")
+	// Managed secret which source was deleted from Vault
+	d.testK8sServerCreateSecret(t, "secret3-v1", "k8s-ns1", annotationName, vaultSecretsPathNamespace+"secret3")
+	// Managed secret from another Vault path
+	d.testK8sServerCreateSecret(t, "secret4-v1", "k8s-ns1", annotationName, "another/path/k8s-ns1/secret4")
+	// Unmanaged secret
+	d.testK8sServerCreateSecret(t, "secret5-v1", "k8s-ns1", "annotation-name", vaultSecretsPathNamespace+"secret5")
+
+	pruned, err := d.pruneSecretsInK8s("k8s-ns1", "k8s-ns1", vaultSecrets, []string{vaultSecretsPathNamespace + "secret2"})
+	if err != nil {
+		t.Log(err)
+		t.Fatal("Error should not be raised")
+	}
+	if pruned != 2 {
+		t.Fatalf("Incorrect number of pruned secrets '%v', expected '2'", pruned)
+	}
+
+	for _, secretName := range []string{"secret1-v1", "secret4-v1", "secret5-v1"} {
+		if _, err := d.testK8sServerReadTestSecret(t, secretName, "k8s-ns1"); err != nil {
+			t.Log(err)
+			t.Fatalf("Secret '%s' shouldn't be pruned", secretName)
+		}
+	}
+	for _, secretName := range []string{"secret2-v1", "secret3-v1"} {
+		if _, err := d.testK8sServerReadTestSecret(t, secretName, "k8s-ns1"); err == nil {
+			t.Fatalf("Secret '%s' should be pruned", secretName)
+		}
+	}
+}
+
+// Test prune managed k8s secrets before grace period is over
+func TestPruneSecretsInK8sGracePeriod(t *testing.T) {
+	d := &vtkData{}
+	_ = d.testK8sServer(t)
+	pruneGracePeriod = 3600
+	pruneMaxPerNamespace = 10
+
+	d.testK8sServerCreateSecret(t, "secret3-v1", "k8s-ns1", annotationName, vaultSecretsPath+"/k8s-ns1/secret3")
+
+	pruned, err := d.pruneSecretsInK8s("k8s-ns1", "k8s-ns1", []string{"secret1"}, nil)
+	if err != nil {
+		t.Log(err)
+		t.Fatal("Error should not be raised")
+	}
+	if pruned != 0 {
+		t.Fatalf("Incorrect number of pruned secrets '%v', expected '0'", pruned)
+	}
+	if _, ok := d.pruneCandidates["k8s-ns1/secret3-v1"]; !ok {
+		t.Fatal("Secret 'secret3-v1' should be a candidate for prune")
+	}
+
+	// Source appeared in Vault again
+	if _, err := d.pruneSecretsInK8s("k8s-ns1", "k8s-ns1", []string{"secret1", "secret3"}, nil); err != nil {
+		t.Log(err)
+		t.Fatal("Error should not be raised")
+	}
+	if _, ok := d.pruneCandidates["k8s-ns1/secret3-v1"]; ok {
+		t.Fatal("Secret 'secret3-v1' shouldn't be a candidate for prune")
+	}
+}
+
+// Test prune managed k8s secrets when their number exceeds safety cap
+func TestPruneSecretsInK8sMaxPerNamespace(t *testing.T) {
+	d := &vtkData{}
+	_ = d.testK8sServer(t)
+	pruneGracePeriod = 0
+	pruneMaxPerNamespace = 1
+
+	d.testK8sServerCreateSecret(t, "secret2-v1", "k8s-ns1", annotationName, vaultSecretsPath+"/k8s-ns1/secret2")
+	d.testK8sServerCreateSecret(t, "secret3-v1", "k8s-ns1", annotationName, vaultSecretsPath+"/k8s-ns1/secret3")
+
+	pruned, err := d.pruneSecretsInK8s("k8s-ns1", "k8s-ns1", []string{"secret1"}, nil)
+	if err == nil {
+		t.Fatal("Expected error, but it wasn't returned")
+	}
+	if !strings.Contains(err.Error(), "Refusing to prune '2' secrets of '2' deleted Vault secrets") {
+		t.Log(err)
+		t.Fatal("Incorrect error response")
+	}
+	if pruned != 0 {
+		t.Fatalf("Incorrect number of pruned secrets '%v', expected '0'", pruned)
+	}
+}
+
+// Test prune all versions of deleted Vault secret when their number exceeds safety cap
+func TestPruneSecretsInK8sMaxPerNamespaceVersions(t *testing.T) {
+	d := &vtkData{}
+	_ = d.testK8sServer(t)
+	pruneGracePeriod = 0
+	pruneMaxPerNamespace = 1
+
+	for _, secretName := range []string{"secret2-v1", "secret2-v2", "secret2-v3"} {
+		d.testK8sServerCreateSecret(t, secretName, "k8s-ns1", annotationName, vaultSecretsPath+"/k8s-ns1/secret2")
+	}
+
+	pruned, err := d.pruneSecretsInK8s("k8s-ns1", "k8s-ns1", []string{"secret1"}, nil)
+	if err != nil {
+		t.Log(err)
+		t.Fatal("Error should not be raised")
+	}
+	if pruned != 3 {
+		t.Fatalf("Incorrect number of pruned secrets '%v', expected '3'", pruned)
+	}
+}
+
+// Test delete superseded versions of managed k8s secrets
+func TestDeleteOldSecretVersionsInK8s(t *testing.T) {
+	d := &vtkData{}
+	_ = d.testK8sServer(t)
+	versionsRetention = 2
+	d.versionsRetentionNamespaces = map[string]int{"k8s-ns2": 0}
+	defer func() { versionsRetention = 0 }()
+
+	secret1Path := vaultSecretsPath + "/k8s-ns1/secret1"
+	for _, secretName := range []string{"secret1-v1", "secret1-v3", "secret1-v4", "secret1-v10", "secret1"} {
+		d.testK8sServerCreateSecret(t, secretName, "k8s-ns1", annotationName, secret1Path)
+	}
+	// Managed version of another Vault secret
+	d.testK8sServerCreateSecret(t, "secret1-v2", "k8s-ns1", annotationName, vaultSecretsPath+"/k8s-ns1/secret2")
+	// Unmanaged version
+	d.testK8sServerCreateSecret(t, "secret1-v5", "k8s-ns1", "annotation-name", secret1Path)
+
+	deleted, err := d.deleteOldSecretVersionsInK8s("k8s-ns1", "k8s-ns1")
+	if err != nil {
+		t.Log(err)
+		t.Fatal("Error should not be raised")
+	}
+	if deleted != 2 {
+		t.Fatalf("Incorrect number of deleted secrets '%v', expected '2'", deleted)
+	}
+
+	for _, secretName := range []string{"secret1-v4", "secret1-v10", "secret1", "secret1-v2", "secret1-v5"} {
+		if _, err := d.testK8sServerReadTestSecret(t, secretName, "k8s-ns1"); err != nil {
+			t.Log(err)
+			t.Fatalf("Secret '%s' shouldn't be deleted", secretName)
+		}
+	}
+	for _, secretName := range []string{"secret1-v1", "secret1-v3"} {
+		if _, err := d.testK8sServerReadTestSecret(t, secretName, "k8s-ns1"); err == nil {
+			t.Fatalf("Secret '%s' should be deleted", secretName)
+		}
+	}
+
+	// Retention disabled for namespace
+	if retention := d.versionsRetention("k8s-ns2"); retention != 0 {
+		t.Fatalf("Incorrect retention '%d' for 'k8s-ns2' namespace, expected '0'", retention)
+	}
+}
+
+// Test delete superseded versions of managed k8s secrets which aren't referenced by workloads
+func TestDeleteOldSecretVersionsInK8sReferenced(t *testing.T) {
+	d := &vtkData{}
+	_ = d.testK8sServer(t)
+	versionsRetention = 0
+	versionsGC = "true"
+	versionsGCPeriod = 0
+	defer func() { versionsGC = "false" }()
+
+	secret1Path := vaultSecretsPath + "/k8s-ns1/secret1"
+	for _, secretName := range []string{"secret1-v1", "secret1-v2", "secret1-v3", "secret1-v4"} {
+		d.testK8sServerCreateSecret(t, secretName, "k8s-ns1", annotationName, secret1Path)
+	}
+
+	// Deployment which mounts 'secret1-v1' as volume
+	deployment := &k8sAppsV1.Deployment{}
+	deployment.Name = "deployment1"
+	deployment.Spec.Template.Spec.Volumes = []k8sCoreV1.Volume{
+		{
+			Name: "secret",
+			VolumeSource: k8sCoreV1.VolumeSource{
+				Secret: &k8sCoreV1.SecretVolumeSource{SecretName: "secret1-v1"},
+			},
+		},
+	}
+	if _, err := d.k8sClient.AppsV1().Deployments("k8s-ns1").Create(deployment); err != nil {
+		t.Fatal(err)
+	}
+	// Pod which uses 'secret1-v2' in envFrom
+	pod := &k8sCoreV1.Pod{}
+	pod.Name = "pod1"
+	pod.Spec.Containers = []k8sCoreV1.Container{
+		{
+			Name: "container1",
+			EnvFrom: []k8sCoreV1.EnvFromSource{
+				{SecretRef: &k8sCoreV1.SecretEnvSource{LocalObjectReference: k8sCoreV1.LocalObjectReference{Name: "secret1-v2"}}},
+			},
+		},
+	}
+	if _, err := d.k8sClient.CoreV1().Pods("k8s-ns1").Create(pod); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := d.deleteOldSecretVersionsInK8s("k8s-ns1", "k8s-ns1")
+	if err != nil {
+		t.Log(err)
+		t.Fatal("Error should not be raised")
+	}
+	if deleted != 1 {
+		t.Fatalf("Incorrect number of deleted secrets '%v', expected '1'", deleted)
+	}
+
+	for _, secretName := range []string{"secret1-v1", "secret1-v2", "secret1-v4"} {
+		if _, err := d.testK8sServerReadTestSecret(t, secretName, "k8s-ns1"); err != nil {
+			t.Log(err)
+			t.Fatalf("Secret '%s' shouldn't be deleted", secretName)
+		}
+	}
+	if _, err := d.testK8sServerReadTestSecret(t, "secret1-v3", "k8s-ns1"); err == nil {
+		t.Fatal("Secret 'secret1-v3' should be deleted")
+	}
+
+	// Unreferenced version should be kept during GC period
+	versionsGCPeriod = 3600
+	if err := d.k8sClient.CoreV1().Pods("k8s-ns1").Delete("pod1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if deleted, _ := d.deleteOldSecretVersionsInK8s("k8s-ns1", "k8s-ns1"); deleted != 0 {
+		t.Fatalf("Incorrect number of deleted secrets '%v', expected '0'", deleted)
+	}
+	if _, ok := d.unreferencedSecrets["k8s-ns1/secret1-v2"]; !ok {
+		t.Fatal("Secret 'secret1-v2' should be a candidate for delete")
+	}
+}
+
+// Test superseded version which is used by old ReplicaSet isn't deleted, so rollback of Deployment works
+func TestDeleteOldSecretVersionsInK8sReplicaSet(t *testing.T) {
+	d := &vtkData{}
+	_ = d.testK8sServer(t)
+	versionsRetention = 0
+	versionsGC = "true"
+	versionsGCPeriod = 0
+	defer func() { versionsGC = "false" }()
+
+	secret1Path := vaultSecretsPath + "/k8s-ns1/secret1"
+	for _, secretName := range []string{"secret1-v1", "secret1-v2", "secret1-v3"} {
+		d.testK8sServerCreateSecret(t, secretName, "k8s-ns1", annotationName, secret1Path)
+	}
+
+	// Old ReplicaSet of Deployment which is scaled to zero and uses 'secret1-v1'
+	replicas := int32(0)
+	replicaSet := &k8sAppsV1.ReplicaSet{}
+	replicaSet.Name = "deployment1-5d8f7c9b6"
+	replicaSet.Spec.Replicas = &replicas
+	replicaSet.Spec.Template.Spec.Containers = []k8sCoreV1.Container{
+		{
+			Name: "container1",
+			EnvFrom: []k8sCoreV1.EnvFromSource{
+				{SecretRef: &k8sCoreV1.SecretEnvSource{LocalObjectReference: k8sCoreV1.LocalObjectReference{Name: "secret1-v1"}}},
+			},
+		},
+	}
+	if _, err := d.k8sClient.AppsV1().ReplicaSets("k8s-ns1").Create(replicaSet); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := d.deleteOldSecretVersionsInK8s("k8s-ns1", "k8s-ns1")
+	if err != nil {
+		t.Log(err)
+		t.Fatal("Error should not be raised")
+	}
+	if deleted != 1 {
+		t.Fatalf("Incorrect number of deleted secrets '%v', expected '1'", deleted)
+	}
+	if _, err := d.testK8sServerReadTestSecret(t, "secret1-v1", "k8s-ns1"); err != nil {
+		t.Fatal("Secret 'secret1-v1' which is used by old ReplicaSet shouldn't be deleted")
+	}
+	if _, err := d.testK8sServerReadTestSecret(t, "secret1-v2", "k8s-ns1"); err == nil {
+		t.Fatal("Secret 'secret1-v2' should be deleted")
+	}
+}
+
+// Test failed delete of superseded version doesn't stop delete of other versions and keeps its GC timestamp
+func TestDeleteOldSecretVersionsInK8sFailed(t *testing.T) {
+	d := &vtkData{}
+	_ = d.testK8sServer(t)
+	versionsRetention = 0
+	versionsGC = "true"
+	versionsGCPeriod = 0
+	defer func() { versionsGC = "false" }()
+
+	secret1Path := vaultSecretsPath + "/k8s-ns1/secret1"
+	for _, secretName := range []string{"secret1-v1", "secret1-v2", "secret1-v3"} {
+		d.testK8sServerCreateSecret(t, secretName, "k8s-ns1", annotationName, secret1Path)
+	}
+	deleteSecretsReactor := func(action k8sTesting.Action) (handled bool, ret runtime.Object, err error) {
+		if action.(k8sTesting.DeleteAction).GetName() == "secret1-v1" {
+			return true, nil, errors.New("delete isn't allowed")
+		}
+		return false, nil, nil
+	}
+	d.k8sClient.(*fake.Clientset).PrependReactor("delete", "secrets", deleteSecretsReactor)
+
+	deleted, err := d.deleteOldSecretVersionsInK8s("k8s-ns1", "k8s-ns1")
+	if err == nil {
+		t.Fatal("Error should be raised")
+	}
+	if deleted != 1 {
+		t.Fatalf("Incorrect number of deleted secrets '%v', expected '1'", deleted)
+	}
+	if _, err := d.testK8sServerReadTestSecret(t, "secret1-v2", "k8s-ns1"); err == nil {
+		t.Fatal("Secret 'secret1-v2' should be deleted")
+	}
+	if _, ok := d.unreferencedSecrets["k8s-ns1/secret1-v1"]; !ok {
+		t.Fatal("Secret 'secret1-v1' should stay a candidate for delete")
+	}
+	if _, ok := d.unreferencedSecrets["k8s-ns1/secret1-v2"]; ok {
+		t.Fatal("Deleted secret 'secret1-v2' shouldn't be a candidate for delete")
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+)
+
+// Configuration file
+type syncConfig struct {
+	Sources []syncSource `json:"sources"`
+}
+
+// Source of secrets for sync, parameters which aren't defined are taken from environment variables (command line parameters)
+type syncSource struct {
+	Name                    string   `json:"name"`
+	SecretsPathVault        string   `json:"secrets_path_vault"`
+	K8sClusterName          string   `json:"k8s_cluster_name"`
+	NonVersioningNamespaces []string `json:"non_versioning_namespaces"`
+	AnnotationName          string   `json:"annotation_name"`
+	NumWorkers              int      `json:"num_workers"`
+
+	NamespacesMapping []namespaceMappingConfig `json:"namespaces_mapping"`
+}
+
+// Read sources from configuration file
+func loadSyncConfig(configFile string) ([]syncSource, error) {
+	content, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error during read configuration file")
+	}
+	config := &syncConfig{}
+	if err := yaml.UnmarshalStrict(content, config); err != nil {
+		return nil, errors.Wrap(err, "Error during parse configuration file '"+configFile+"'")
+	}
+	if len(config.Sources) == 0 {
+		return nil, fmt.Errorf("There are no sources in configuration file '%s'", configFile)
+	}
+
+	names := make(map[string]bool)
+	for i := range config.Sources {
+		source := &config.Sources[i]
+		source.SecretsPathVault = strings.Trim(source.SecretsPathVault, "/")
+		if source.SecretsPathVault == "" {
+			return nil, fmt.Errorf("'secrets_path_vault' should be defined for source #%d in configuration file", i+1)
+		}
+		if source.Name == "" {
+			source.Name = source.SecretsPathVault
+		}
+		if names[source.Name] {
+			return nil, fmt.Errorf("Source '%s' is duplicated in configuration file", source.Name)
+		}
+		names[source.Name] = true
+
+		if source.K8sClusterName == "" {
+			source.K8sClusterName = k8sClusterName
+		}
+		if source.K8sClusterName == "" {
+			return nil, fmt.Errorf("'k8s_cluster_name' should be defined for source '%s' in configuration file or K8S_CLUSTER_NAME should be set", source.Name)
+		}
+		if source.NonVersioningNamespaces == nil && nonVersioningNamespaces != "" {
+			source.NonVersioningNamespaces = strings.Split(nonVersioningNamespaces, ",")
+		}
+		if source.AnnotationName == "" {
+			source.AnnotationName = annotationName
+		}
+		if source.NumWorkers < 0 {
+			return nil, fmt.Errorf("'num_workers' should be greater than 0 for source '%s' in configuration file", source.Name)
+		}
+		if source.NumWorkers == 0 {
+			source.NumWorkers = numWorkers
+		}
+	}
+
+	return config.Sources, nil
+}
+
+// Data of sync source, it shares Vault and k8s clients (and therefore Vault token) with application
+func (d *vtkData) sourceData(source syncSource) (*vtkData, error) {
+	mapping := d.namespacesMapping
+	if source.NamespacesMapping != nil {
+		var err error
+		mapping, err = namespacesMappingRules(source.NamespacesMapping)
+		if err != nil {
+			return nil, errors.Wrap(err, "Incorrect namespaces mapping for source '"+source.Name+"' in configuration file")
+		}
+	}
+
+	return &vtkData{
+		vaultClient:                 d.vaultClient,
+		k8sClient:                   d.k8sClient,
+		source:                      &source,
+		nonVersioningNamespacesList: source.NonVersioningNamespaces,
+		namespacesMapping:           mapping,
+		namespacesSelector:          d.namespacesSelector,
+		deniedNamespaces:            d.deniedNamespaces,
+		eventRecorder:               d.eventRecorder,
+		versionsRetentionNamespaces: d.versionsRetentionNamespaces,
+	}, nil
+}
+
+// Data of all sync sources
+func (d *vtkData) syncSources() []*vtkData {
+	if len(d.sources) == 0 {
+		return []*vtkData{d}
+	}
+
+	return d.sources
+}
+
+// Name of sync source for log messages
+func (d *vtkData) sourceName() string {
+	if d.source == nil {
+		return ""
+	}
+
+	return d.source.Name
+}
+
+// Path to secrets in Vault
+func (d *vtkData) vaultSecretsPath() string {
+	if d.source == nil {
+		return vaultSecretsPath
+	}
+
+	return d.source.SecretsPathVault
+}
+
+// Name of k8s cluster
+func (d *vtkData) k8sClusterName() string {
+	if d.source == nil {
+		return k8sClusterName
+	}
+
+	return d.source.K8sClusterName
+}
+
+// Annotation name for k8s objects
+func (d *vtkData) annotationName() string {
+	if d.source == nil {
+		return annotationName
+	}
+
+	return d.source.AnnotationName
+}
+
+// Number of workers
+func (d *vtkData) numWorkers() int {
+	if d.source == nil {
+		return numWorkers
+	}
+
+	return d.source.NumWorkers
+}
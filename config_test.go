@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	k8sCoreV1 "k8s.io/api/core/v1"
+)
+
+// Write configuration file for tests
+func testConfigFile(t *testing.T, content string) string {
+	t.Helper()
+
+	f, err := ioutil.TempFile("", "vault-to-k8s-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+
+	return f.Name()
+}
+
+// Test load sync sources from configuration file with defaults from parameters
+func TestLoadSyncConfig(t *testing.T) {
+	defer defineAppInitParams()
+	numWorkers = 3
+	nonVersioningNamespaces = "k8s-ns-nonver"
+	defer func() { numWorkers = 0; nonVersioningNamespaces = "" }()
+
+	configFile := testConfigFile(t, `
+sources:
+  - secrets_path_vault: /testMount/k8s/dev/
+  - name: prod
+    secrets_path_vault: testMount/k8s/prod
+    k8s_cluster_name: prod-cluster
+    non_versioning_namespaces: [k8s-ns1, k8s-ns2]
+    annotation_name: vault-to-k8s/prod-secret
+    num_workers: 5
+    namespaces_mapping:
+      - vault_dir: team-a
+        namespaces: [team-a-prod, team-a-canary]
+`)
+	defer os.Remove(configFile)
+
+	sources, err := loadSyncConfig(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sources) != 2 {
+		t.Fatalf("Expected 2 sources, got '%v'", sources)
+	}
+
+	// Parameters of source are taken from environment variables if they aren't defined
+	dev := sources[0]
+	if dev.Name != "testMount/k8s/dev" || dev.SecretsPathVault != "testMount/k8s/dev" || dev.K8sClusterName != k8sClusterName || dev.AnnotationName != annotationName || dev.NumWorkers != 3 {
+		t.Fatalf("Incorrect parameters of source: '%v'", dev)
+	}
+	if len(dev.NonVersioningNamespaces) != 1 || dev.NonVersioningNamespaces[0] != "k8s-ns-nonver" {
+		t.Fatalf("Incorrect non-versioning namespaces of source: '%v'", dev.NonVersioningNamespaces)
+	}
+
+	prod := sources[1]
+	if prod.Name != "prod" || prod.K8sClusterName != "prod-cluster" || prod.AnnotationName != "vault-to-k8s/prod-secret" || prod.NumWorkers != 5 || len(prod.NonVersioningNamespaces) != 2 {
+		t.Fatalf("Incorrect parameters of source: '%v'", prod)
+	}
+
+	// Namespaces mapping of source overrides 'NAMESPACES_MAPPING'
+	d := &vtkData{}
+	prodData, err := d.sourceData(prod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if namespaces := prodData.mapNamespaces("team-a"); len(namespaces) != 2 || namespaces[1] != "team-a-canary" {
+		t.Fatalf("Incorrect namespaces for 'team-a' Vault directory: '%v'", namespaces)
+	}
+	if _, err := d.sourceData(syncSource{NamespacesMapping: []namespaceMappingConfig{{VaultDir: "team-a"}}}); err == nil {
+		t.Fatal("Expected error for mapping rule without namespaces")
+	}
+}
+
+// Test errors of incorrect configuration file
+func TestLoadSyncConfigErrors(t *testing.T) {
+	defer defineAppInitParams()
+
+	tests := map[string]string{
+		"There are no sources":             "sources: []",
+		"'secrets_path_vault' should be":   "sources:\n  - name: dev",
+		"is duplicated":                    "sources:\n  - secrets_path_vault: testMount/k8s/dev\n  - secrets_path_vault: testMount/k8s/dev",
+		"Error during parse configuration": "sources:\n  - secrets_path: testMount/k8s/dev",
+		"'num_workers' should be":          "sources:\n  - secrets_path_vault: testMount/k8s/dev\n    num_workers: -1",
+	}
+	for expectedErr, content := range tests {
+		configFile := testConfigFile(t, content)
+		_, err := loadSyncConfig(configFile)
+		os.Remove(configFile)
+		if err == nil || !strings.Contains(err.Error(), expectedErr) {
+			t.Fatalf("Expected error '%s' for config '%s', got '%v'", expectedErr, content, err)
+		}
+	}
+}
+
+// Test sync cycle of several sources to the same k8s namespaces
+func TestSyncCycleSources(t *testing.T) {
+	d := &vtkData{}
+	tvsd := d.testVaultServer(t)
+	defer tvsd.server.Close()
+	_ = d.testK8sServer(t)
+	defer defineAppInitParams()
+	numWorkers = 2
+
+	vaultSecretsPath = "testMount/k8s/prod"
+	d.testVaultServerCreateSecrets(t, []string{"prod-app"}, "k8s-ns1")
+	d.testVaultServerCreateSecrets(t, []string{"prod-app"}, "k8s-ns-prod-terminating")
+	defineAppInitParams()
+	d.testVaultServerCreateSecrets(t, []string{"dev-app"}, "k8s-ns-dev-terminating")
+
+	namespace := &k8sCoreV1.Namespace{}
+	namespace.Name = "k8s-ns1"
+	if _, err := d.k8sClient.CoreV1().Namespaces().Create(namespace); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"k8s-ns-prod-terminating", "k8s-ns-dev-terminating"} {
+		namespace := &k8sCoreV1.Namespace{}
+		namespace.Name = name
+		namespace.Status.Phase = k8sCoreV1.NamespaceTerminating
+		if _, err := d.k8sClient.CoreV1().Namespaces().Create(namespace); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, source := range []syncSource{
+		{Name: "dev", SecretsPathVault: "testMount/k8s/dev", K8sClusterName: k8sClusterName, AnnotationName: annotationName, NumWorkers: 2},
+		{Name: "prod", SecretsPathVault: "testMount/k8s/prod", K8sClusterName: k8sClusterName, AnnotationName: "vault-to-k8s/prod-secret", NumWorkers: 1},
+	} {
+		sourceData, err := d.sourceData(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.sources = append(d.sources, sourceData)
+	}
+	if !d.syncCycle(context.Background()) {
+		t.Fatal("Sync should be successful")
+	}
+
+	// Secrets of each source are synced with annotation of source
+	secret, err := d.testK8sServerReadTestSecret(t, "secret1-v2", "k8s-ns1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Annotations[annotationName] != "testMount/k8s/dev/k8s-ns1/secret1" {
+		t.Fatalf("Incorrect annotations of secret 'secret1-v2': '%v'", secret.Annotations)
+	}
+	secret, err = d.testK8sServerReadTestSecret(t, "prod-app-v1", "k8s-ns1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Annotations["vault-to-k8s/prod-secret"] != "testMount/k8s/prod/k8s-ns1/prod-app" {
+		t.Fatalf("Incorrect annotations of secret 'prod-app-v1': '%v'", secret.Annotations)
+	}
+
+	// Excluded namespaces of all sources are counted
+	if count := readMetricValue(namespacesExcluded.WithLabelValues(excludedTerminating)); count != 2 {
+		t.Fatalf("Expected '2' namespaces excluded by '%s', got '%v'", excludedTerminating, count)
+	}
+
+	// Metrics of namespace synced by both sources are kept per source
+	if created := readMetricValue(secretsCreated.WithLabelValues("prod", "k8s-ns1")); created != 1 {
+		t.Fatalf("Expected '1' secret created by 'prod' source in 'k8s-ns1' namespace, got '%v'", created)
+	}
+	if created := readMetricValue(secretsCreated.WithLabelValues("dev", "k8s-ns1")); created < 2 {
+		t.Fatalf("Expected secrets created by 'dev' source in 'k8s-ns1' namespace, got '%v'", created)
+	}
+	for _, source := range []string{"dev", "prod"} {
+		if status := readMetricValue(syncStatus.WithLabelValues(source, "k8s-ns1")); status != 1 {
+			t.Fatalf("Expected sync status '1' of '%s' source for 'k8s-ns1' namespace, got '%v'", source, status)
+		}
+	}
+}
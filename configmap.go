@@ -0,0 +1,115 @@
+package main
+
+import (
+	"path"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	k8sCoreV1 "k8s.io/api/core/v1"
+	k8sApiErr "k8s.io/apimachinery/pkg/api/errors"
+	k8sMetaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Key in 'custom_metadata' of KV v2 secret with kind of k8s object
+const kindMetadataKey = "k8s-kind"
+
+// Check if Vault secret should be synced to k8s ConfigMap instead of secret
+func isConfigMap(vaultSecretName string, customMetadata map[string]string) bool {
+	if configMaps != "true" {
+		return false
+	}
+	if kind, ok := customMetadata[kindMetadataKey]; ok {
+		return strings.EqualFold(strings.TrimSpace(kind), "configmap")
+	}
+
+	return configMapsPrefix != "" && strings.HasPrefix(path.Base(vaultSecretName), configMapsPrefix)
+}
+
+// List of K8s ConfigMaps
+func (d *vtkData) k8sConfigMapsList(namespace string) ([]string, error) {
+	start := time.Now()
+	k8sNSObj, err := d.k8sClient.CoreV1().ConfigMaps(namespace).List(k8sMetaV1.ListOptions{})
+	observeAPIRequest(backendK8s, "list_configmaps", start, err)
+	if err != nil {
+		return nil, err
+	}
+	k8sConfigMaps := []string{}
+	for _, v := range k8sNSObj.Items {
+		k8sConfigMaps = append(k8sConfigMaps, v.Name)
+	}
+
+	return k8sConfigMaps, nil
+}
+
+// Create/update ConfigMap in k8s, error is returned only if sync of namespace should be stopped
+func (d *vtkData) updateConfigMapInK8s(numWorkerStr, namespace, vaultSecretPathFull string, configMap *k8sCoreV1.ConfigMap, metadata *vaultSecretMetadata, updateResults *updateSecretResults) error {
+	// Read k8s ConfigMap
+	start := time.Now()
+	existing, err := d.k8sClient.CoreV1().ConfigMaps(namespace).Get(configMap.Name, k8sMetaV1.GetOptions{})
+	observeAPIRequest(backendK8s, "get_configmap", start, err)
+	if err != nil && !k8sApiErr.IsNotFound(err) {
+		glog.Errorln(numWorkerStr+"Error during get k8s configmap:", err)
+		updateResults.skipped++
+		updateResults.addPlan(configMap.Name, vaultSecretPathFull, actionSkip, "error during get k8s configmap: "+err.Error())
+		d.recordNamespaceEvent(namespace, k8sCoreV1.EventTypeWarning, eventSyncFailed, "Failed to get configmap '"+configMap.Name+"' for Vault secret '"+vaultSecretPathFull+"': "+err.Error())
+		return nil
+	}
+
+	// Decide what should be done with k8s ConfigMap
+	var action, reason string
+	if err != nil {
+		action, reason = d.k8sObjectAction(vaultSecretPathFull, false, nil, false, "")
+	} else {
+		upToDate := reflect.DeepEqual(existing.Data, configMap.Data) == true && (metadata == nil || (existing.Annotations[d.versionAnnotationName()] == configMap.Annotations[d.versionAnnotationName()] && existing.Annotations[d.updatedTimeAnnotationName()] == metadata.updatedTime))
+		action, reason = d.k8sObjectAction(vaultSecretPathFull, true, existing.Annotations, upToDate, "")
+	}
+	updateResults.addPlan(configMap.Name, vaultSecretPathFull, action, reason)
+	if d.readOnly() {
+		return nil
+	}
+
+	switch action {
+	case actionCreate:
+		// Create new ConfigMap
+		glog.V(2).Infoln(numWorkerStr + "Create k8s configmap '" + configMap.Name + "' from vault secret '" + vaultSecretPathFull + "'")
+		start := time.Now()
+		created, err := d.k8sClient.CoreV1().ConfigMaps(namespace).Create(configMap)
+		observeAPIRequest(backendK8s, "create_configmap", start, err)
+		if err != nil {
+			glog.Errorln(errors.Wrap(err, numWorkerStr+"Error during create k8s configmap"))
+			updateResults.skipped++
+			d.recordNamespaceEvent(namespace, k8sCoreV1.EventTypeWarning, eventSyncFailed, "Failed to create configmap '"+configMap.Name+"' from Vault secret '"+vaultSecretPathFull+"': "+err.Error())
+			return nil
+		}
+		d.recordEvent(created, k8sCoreV1.EventTypeNormal, eventCreated, "Created from Vault secret '"+vaultSecretPathFull+"'")
+		updateResults.created++
+		updateResults.synced++
+	case actionNone:
+		// Skip update non-versioning ConfigMap if it already up-to-date in k8s
+		glog.V(2).Infoln(numWorkerStr + "Ignoring update configmap '" + configMap.Name + "' in '" + namespace + "' namespace as it already up-to-date")
+		updateResults.synced++
+	case actionSkip:
+		// ConfigMap isn't managed by application or belongs to another Vault secret
+		glog.V(2).Infoln(numWorkerStr + "WARNING: Ignoring k8s configmap '" + configMap.Name + "' in '" + namespace + "' namespace as it " + reason)
+		updateResults.skipped++
+		d.recordEvent(existing, k8sCoreV1.EventTypeWarning, d.skipEventReason(existing.Annotations), "Not synced from Vault secret '"+vaultSecretPathFull+"' as it "+reason)
+	case actionUpdate:
+		// Update ConfigMap
+		glog.V(2).Infoln(numWorkerStr + "Update k8s configmap '" + configMap.Name + "' from vault secret '" + vaultSecretPathFull + "'")
+		start := time.Now()
+		updated, err := d.k8sClient.CoreV1().ConfigMaps(namespace).Update(configMap)
+		observeAPIRequest(backendK8s, "update_configmap", start, err)
+		if err != nil {
+			d.recordEvent(existing, k8sCoreV1.EventTypeWarning, eventSyncFailed, "Failed to update from Vault secret '"+vaultSecretPathFull+"': "+err.Error())
+			return errors.Wrap(err, "Error during update k8s configmap")
+		}
+		d.recordEvent(updated, k8sCoreV1.EventTypeNormal, eventUpdated, "Updated from Vault secret '"+vaultSecretPathFull+"'")
+		updateResults.updated++
+		updateResults.synced++
+	}
+
+	return nil
+}
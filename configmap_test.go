@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+
+	k8sMetaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Test check if Vault secret should be synced to ConfigMap
+func TestIsConfigMap(t *testing.T) {
+	defer defineAppInitParams()
+	configMaps = "true"
+	configMapsPrefix = "config-"
+
+	tests := []struct {
+		name           string
+		customMetadata map[string]string
+		expected       bool
+	}{
+		{"secret1", nil, false},
+		{"config-app", nil, true},
+		{"dir/config-app", nil, true},
+		{"secret1", map[string]string{kindMetadataKey: "ConfigMap"}, true},
+		{"config-app", map[string]string{kindMetadataKey: "secret"}, false},
+	}
+	for _, test := range tests {
+		if isConfigMap(test.name, test.customMetadata) != test.expected {
+			t.Fatalf("Incorrect result for '%s' with '%v', expected '%t'", test.name, test.customMetadata, test.expected)
+		}
+	}
+
+	configMaps = "false"
+	if isConfigMap("config-app", nil) {
+		t.Fatal("Vault secret shouldn't be synced to ConfigMap if ConfigMaps are disabled")
+	}
+}
+
+// Test create/update ConfigMaps in k8s
+func TestUpdateSecretsInK8sConfigMaps(t *testing.T) {
+	d := &vtkData{}
+	tvsd := d.testVaultServer(t)
+	defer tvsd.server.Close()
+	_ = d.testK8sServer(t)
+	defer defineAppInitParams()
+	configMaps = "true"
+	configMapsPrefix = "config-"
+
+	d.testVaultServerCreateSecrets(t, []string{"config-app", "app-secret"}, "k8s-ns1")
+
+	numWorkers = 2
+	d.testRunUpdateSecretsInK8s(t, map[string]int{"config-app": 0, "app-secret": 1}, "k8s-ns1")
+
+	// Versioning and non-versioning ConfigMaps should be created
+	for _, configMapName := range []string{"config-app-v1", "config-app"} {
+		configMap, err := d.k8sClient.CoreV1().ConfigMaps("k8s-ns1").Get(configMapName, k8sMetaV1.GetOptions{})
+		if err != nil {
+			t.Log(err)
+			t.Fatalf("ConfigMap '%s' should be created", configMapName)
+		}
+		if configMap.Data["testKey-config-app"] != "testValue-config-app" {
+			t.Fatalf("Incorrect value '%s' in ConfigMap '%s', expected 'testValue-config-app'", configMap.Data["testKey-config-app"], configMapName)
+		}
+		if configMap.Annotations[annotationName] != vaultSecretsPath+"/k8s-ns1/config-app" {
+			t.Fatalf("Incorrect annotation '%s' of ConfigMap '%s'", configMap.Annotations[annotationName], configMapName)
+		}
+	}
+	if _, err := d.testK8sServerReadTestSecret(t, "config-app-v1", "k8s-ns1"); err == nil {
+		t.Fatal("Secret 'config-app-v1' shouldn't be created")
+	}
+	if _, err := d.testK8sServerReadTestSecret(t, "app-secret-v1", "k8s-ns1"); err != nil {
+		t.Log(err)
+		t.Fatal("Secret 'app-secret-v1' should be created")
+	}
+
+	// Non-versioning ConfigMap should be updated from new version
+	if _, err := d.vaultClient.Logical().Write("testMount/data/k8s/dev/k8s-ns1/config-app", map[string]interface{}{"data": map[string]interface{}{"testKey-config-app": "newValue"}}); err != nil {
+		t.Fatal(err)
+	}
+	d.testRunUpdateSecretsInK8s(t, map[string]int{"config-app": 0}, "k8s-ns1")
+	configMap, err := d.k8sClient.CoreV1().ConfigMaps("k8s-ns1").Get("config-app", k8sMetaV1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configMap.Data["testKey-config-app"] != "newValue" {
+		t.Fatalf("Incorrect value '%s' in ConfigMap 'config-app', expected 'newValue'", configMap.Data["testKey-config-app"])
+	}
+	if _, err := d.k8sClient.CoreV1().ConfigMaps("k8s-ns1").Get("config-app-v2", k8sMetaV1.GetOptions{}); err != nil {
+		t.Log(err)
+		t.Fatal("ConfigMap 'config-app-v2' should be created")
+	}
+}
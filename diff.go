@@ -0,0 +1,309 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	k8sApiErr "k8s.io/apimachinery/pkg/api/errors"
+	k8sMetaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Statuses of keys in diff
+const (
+	keyAdded     = "+" // Key exists only in Vault
+	keyRemoved   = "-" // Key exists only in k8s
+	keyChanged   = "~" // Values are different
+	keyUnchanged = " " // Values are the same
+)
+
+// Difference between Vault secret and k8s object
+type secretDiff struct {
+	planEntry
+	exists     bool      // k8s object exists
+	annotation string    // Value of ownership annotation of k8s object
+	keys       []keyDiff // Differences of data keys
+}
+
+// Difference of one data key
+type keyDiff struct {
+	key        string
+	status     string
+	vaultValue []byte
+	k8sValue   []byte
+}
+
+// Run 'diff' subcommand, returns exit code
+func (d *vtkData) runDiff(args []string, w io.Writer) int {
+	flags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	flags.SetOutput(w)
+	hash := flags.Bool("hash", false, "Show HMAC-SHA256 hashes of values with random key of this run instead of masked values, hashes can be compared only within one output")
+	sourceName := flags.String("source", "", "Name of source from configuration file (the first source by default)")
+	flags.Usage = func() {
+		fmt.Fprintln(w, "Usage: "+appName+" [parameters] diff [-hash] [-source <name>] <namespace> [secret]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		flags.Usage()
+		return 2
+	}
+
+	source := d.syncSources()[0]
+	if *sourceName != "" {
+		source = nil
+		for _, s := range d.syncSources() {
+			if s.sourceName() == *sourceName {
+				source = s
+				break
+			}
+		}
+		if source == nil {
+			glog.Errorln("Source '" + *sourceName + "' wasn't found in configuration file")
+			return 2
+		}
+	}
+
+	if err := d.authenticateReadOnly(); err != nil {
+		glog.Errorln(err)
+		return 1
+	}
+	defer d.revokeReadOnlyToken()
+	if err := source.verifyVaultMount(); err != nil {
+		glog.Errorln(err)
+		return 1
+	}
+
+	vaultDir, err := source.namespaceVaultDir(flags.Arg(0))
+	if err != nil {
+		glog.Errorln(err)
+		return 1
+	}
+	diffs, err := source.diffSecrets(vaultDir, flags.Arg(0), flags.Arg(1))
+	if err != nil {
+		glog.Errorln(err)
+		return 1
+	}
+	source.printDiff(w, diffs, *hash)
+
+	return 0
+}
+
+// Vault directory which is synced to k8s namespace
+func (d *vtkData) namespaceVaultDir(namespace string) (string, error) {
+	vaultNamespaces, err := d.vaultNamespacesList()
+	if err != nil {
+		return "", err
+	}
+	for _, ns := range d.mapVaultDirs(vaultNamespaces) {
+		if ns.namespace == namespace {
+			return ns.vaultDir, nil
+		}
+	}
+
+	return "", fmt.Errorf("There is no Vault directory under '%s' which is synced to '%s' namespace", d.vaultSecretsPath(), namespace)
+}
+
+// Compare Vault secrets of directory (or only one secret) with k8s objects in namespace
+func (d *vtkData) diffSecrets(vaultDir, namespace, secretName string) ([]secretDiff, error) {
+	k8sClusterNameSuffix := "." + d.k8sClusterName()
+	// Diff never changes k8s objects and compares data of all objects regardless of metadata
+	d.planOnly = true
+	defer func() { d.planOnly = false }()
+
+	secrets, err := d.secretsList(vaultDir)
+	if err != nil {
+		return nil, err
+	}
+	filteredSecrets := d.filterSecrets(secrets, k8sClusterNameSuffix, namespace)
+	names := []string{}
+	if secretName != "" {
+		found := false
+		for _, secret := range secrets {
+			if secret == secretName {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("Secret '%s' wasn't found in Vault under '%s' directory", secretName, vaultDir)
+		}
+		if _, ok := filteredSecrets[secretName]; !ok {
+			diff := secretDiff{}
+			diff.vaultPath = d.vaultSecretsPath() + "/" + vaultDir + "/" + secretName
+			diff.action = actionSkip
+			diff.reason = "it's filtered out by name rules for '" + d.k8sClusterName() + "' cluster"
+			return []secretDiff{diff}, nil
+		}
+		names = append(names, secretName)
+	} else {
+		for name := range filteredSecrets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	k8sSecrets, err := d.k8sSecretsList(namespace)
+	if err != nil {
+		return nil, err
+	}
+	k8sConfigMaps := []string{}
+	if configMaps == "true" {
+		if k8sConfigMaps, err = d.k8sConfigMapsList(namespace); err != nil {
+			return nil, err
+		}
+	}
+
+	diffs := []secretDiff{}
+	for _, name := range names {
+		// Get planned actions for k8s objects of Vault secret
+		results := d.updateSecretInK8s("", secretForUpdate{name: name, versioning: filteredSecrets[name]}, vaultDir, namespace, k8sClusterNameSuffix, k8sSecrets, k8sConfigMaps)
+		if results.err != nil {
+			return nil, results.err
+		}
+		vaultData := results.data
+		if vaultData == nil {
+			vaultData = map[string][]byte{}
+		}
+
+		for _, entry := range results.plan {
+			diff := secretDiff{planEntry: entry}
+			// k8s object can't exist if it wasn't defined or has invalid name
+			if entry.name != "" && validation.IsDNS1123Subdomain(entry.name) == nil {
+				if err := d.diffK8sObject(namespace, &diff, vaultData); err != nil {
+					return nil, err
+				}
+			}
+			diffs = append(diffs, diff)
+		}
+	}
+
+	return diffs, nil
+}
+
+// Compare data of k8s object with data of Vault secret
+func (d *vtkData) diffK8sObject(namespace string, diff *secretDiff, vaultData map[string][]byte) error {
+	k8sData := map[string][]byte{}
+	var annotations map[string]string
+	if diff.kind == kindConfigMap {
+		start := time.Now()
+		configMap, err := d.k8sClient.CoreV1().ConfigMaps(namespace).Get(diff.name, k8sMetaV1.GetOptions{})
+		observeAPIRequest(backendK8s, "get_configmap", start, err)
+		if k8sApiErr.IsNotFound(err) {
+			diff.keys = diffKeys(vaultData, k8sData)
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, "Error during get k8s configmap")
+		}
+		for k, v := range configMap.Data {
+			k8sData[k] = []byte(v)
+		}
+		annotations = configMap.Annotations
+	} else {
+		start := time.Now()
+		secret, err := d.k8sClient.CoreV1().Secrets(namespace).Get(diff.name, k8sMetaV1.GetOptions{})
+		observeAPIRequest(backendK8s, "get_secret", start, err)
+		if k8sApiErr.IsNotFound(err) {
+			diff.keys = diffKeys(vaultData, k8sData)
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, "Error during get k8s secret")
+		}
+		k8sData = secret.Data
+		annotations = secret.Annotations
+	}
+
+	diff.exists = true
+	diff.annotation = annotations[d.annotationName()]
+	diff.keys = diffKeys(vaultData, k8sData)
+
+	return nil
+}
+
+// Compare keys of Vault secret and k8s object
+func diffKeys(vaultData, k8sData map[string][]byte) []keyDiff {
+	keys := []keyDiff{}
+	for k, v := range vaultData {
+		k8sValue, ok := k8sData[k]
+		if !ok {
+			keys = append(keys, keyDiff{key: k, status: keyAdded, vaultValue: v})
+		} else if string(k8sValue) != string(v) {
+			keys = append(keys, keyDiff{key: k, status: keyChanged, vaultValue: v, k8sValue: k8sValue})
+		} else {
+			keys = append(keys, keyDiff{key: k, status: keyUnchanged, vaultValue: v, k8sValue: k8sValue})
+		}
+	}
+	for k, v := range k8sData {
+		if _, ok := vaultData[k]; !ok {
+			keys = append(keys, keyDiff{key: k, status: keyRemoved, k8sValue: v})
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].key < keys[j].key })
+
+	return keys
+}
+
+// Random key of hashes of values, it's generated on each run, so hashes can be compared only within one output and can't be matched with known values
+var diffHashKey = func() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		glog.Fatal(errors.Wrap(err, "Error during generate key for hashes of values"))
+	}
+	return key
+}()
+
+// Masked or hashed value for output
+func diffValue(value []byte, hash bool) string {
+	if !hash {
+		return "<masked>"
+	}
+	mac := hmac.New(sha256.New, diffHashKey)
+	mac.Write(value)
+
+	return "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil))[:12]
+}
+
+// Print differences between Vault secrets and k8s objects
+func (d *vtkData) printDiff(w io.Writer, diffs []secretDiff, hash bool) {
+	for _, diff := range diffs {
+		action := "would-" + diff.action
+		if diff.action == actionNone {
+			action = "up-to-date"
+		}
+		if diff.name == "" {
+			fmt.Fprintf(w, "Vault secret '%s': %s (%s)\n", diff.vaultPath, action, diff.reason)
+		} else {
+			fmt.Fprintf(w, "%s '%s' from '%s': %s (%s)\n", diff.kind, diff.name, diff.vaultPath, action, diff.reason)
+		}
+		if diff.exists {
+			annotation := diff.annotation
+			if annotation == "" {
+				annotation = "<missing>"
+			}
+			fmt.Fprintf(w, "  annotation '%s': %s\n", d.annotationName(), annotation)
+		}
+		for _, key := range diff.keys {
+			switch key.status {
+			case keyAdded:
+				fmt.Fprintf(w, "  %s %s: %s\n", key.status, key.key, diffValue(key.vaultValue, hash))
+			case keyRemoved:
+				fmt.Fprintf(w, "  %s %s: %s\n", key.status, key.key, diffValue(key.k8sValue, hash))
+			case keyChanged:
+				fmt.Fprintf(w, "  %s %s: %s -> %s\n", key.status, key.key, diffValue(key.k8sValue, hash), diffValue(key.vaultValue, hash))
+			default:
+				fmt.Fprintf(w, "  %s %s: %s\n", key.status, key.key, diffValue(key.vaultValue, hash))
+			}
+		}
+	}
+}
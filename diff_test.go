@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// Test differences between Vault secrets and k8s objects of namespace
+func TestDiffSecrets(t *testing.T) {
+	d := &vtkData{}
+	tvsd := d.testVaultServer(t)
+	defer tvsd.server.Close()
+	_ = d.testK8sServer(t)
+	defer defineAppInitParams()
+	defer func() { metadataChangeDetection = "false" }()
+	metadataChangeDetection = "true"
+
+	d.testVaultServerCreateSecrets(t, []string{"app-changed", "app-new", "app-other.other-cluster"}, "k8s-ns-diff")
+	d.testK8sServerCreateSecret(t, "app-changed-v1", "k8s-ns-diff", annotationName, vaultSecretsPath+"/k8s-ns-diff/app-changed")
+
+	diffs, err := d.diffSecrets("k8s-ns-diff", "k8s-ns-diff", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 2 {
+		t.Fatalf("Expected diff for 2 secrets, got '%v'", diffs)
+	}
+
+	// Existing version isn't updated even if it has other keys
+	if diffs[0].name != "app-changed-v1" || diffs[0].action != actionNone || !diffs[0].exists {
+		t.Fatalf("Incorrect diff for 'app-changed-v1': '%v'", diffs[0])
+	}
+	if diffs[0].annotation != vaultSecretsPath+"/k8s-ns-diff/app-changed" {
+		t.Fatalf("Incorrect annotation '%s' in diff", diffs[0].annotation)
+	}
+	expectedKeys := []keyDiff{
+		{key: "testK8sKey-app-changed-v1", status: keyRemoved},
+		{key: "testKey-app-changed", status: keyAdded},
+	}
+	if len(diffs[0].keys) != len(expectedKeys) {
+		t.Fatalf("Expected keys '%v', got '%v'", expectedKeys, diffs[0].keys)
+	}
+	for i, key := range expectedKeys {
+		if diffs[0].keys[i].key != key.key || diffs[0].keys[i].status != key.status {
+			t.Fatalf("Expected key '%v', got '%v'", key, diffs[0].keys[i])
+		}
+	}
+
+	// Secret doesn't exist in k8s
+	if diffs[1].name != "app-new-v1" || diffs[1].action != actionCreate || diffs[1].exists {
+		t.Fatalf("Incorrect diff for 'app-new-v1': '%v'", diffs[1])
+	}
+
+	// Secret of other cluster is filtered out
+	diffs, err = d.diffSecrets("k8s-ns-diff", "k8s-ns-diff", "app-other.other-cluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 1 || diffs[0].action != actionSkip || !strings.Contains(diffs[0].reason, "filtered out") {
+		t.Fatalf("Secret 'app-other.other-cluster' should be skipped as filtered out, got '%v'", diffs)
+	}
+
+	if _, err := d.diffSecrets("k8s-ns-diff", "k8s-ns-diff", "app-missing"); err == nil {
+		t.Fatal("Expected error for missing secret, but it wasn't returned")
+	}
+
+	// k8s objects shouldn't be changed
+	secret, err := d.testK8sServerReadTestSecret(t, "app-changed-v1", "k8s-ns-diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := secret.Data["testKey-app-changed"]; ok {
+		t.Fatal("Secret 'app-changed-v1' shouldn't be updated by diff")
+	}
+	if _, err := d.testK8sServerReadTestSecret(t, "app-new-v1", "k8s-ns-diff"); err == nil {
+		t.Fatal("Secret 'app-new-v1' shouldn't be created by diff")
+	}
+
+	// Parameters of application shouldn't be changed
+	if dryRun != "false" || metadataChangeDetection != "true" || d.planOnly {
+		t.Fatalf("Parameters shouldn't be changed by diff: dry-run '%s', metadata change detection '%s', plan only '%t'", dryRun, metadataChangeDetection, d.planOnly)
+	}
+}
+
+// Test print of differences with masked and hashed values
+func TestPrintDiff(t *testing.T) {
+	d := &vtkData{}
+	diffs := []secretDiff{{
+		planEntry: planEntry{kind: kindSecret, name: "app-v1", vaultPath: "secrets/ns/app", action: actionUpdate, reason: "data or metadata was changed"},
+		exists:    true,
+		keys: []keyDiff{
+			{key: "password", status: keyChanged, vaultValue: []byte("new-password"), k8sValue: []byte("old-password")},
+		},
+	}}
+
+	var masked bytes.Buffer
+	d.printDiff(&masked, diffs, false)
+	if strings.Contains(masked.String(), "password:") == false || strings.Contains(masked.String(), "new-password") {
+		t.Fatalf("Values should be masked, got '%s'", masked.String())
+	}
+	if !strings.Contains(masked.String(), "annotation '"+annotationName+"': <missing>") {
+		t.Fatalf("Missing annotation should be shown, got '%s'", masked.String())
+	}
+
+	var hashed bytes.Buffer
+	d.printDiff(&hashed, diffs, true)
+	if !strings.Contains(hashed.String(), "~ password: "+diffValue([]byte("old-password"), true)+" -> "+diffValue([]byte("new-password"), true)) {
+		t.Fatalf("Values should be hashed, got '%s'", hashed.String())
+	}
+
+	// Hash is keyed, so it can't be matched with hash of known value
+	sum := sha256.Sum256([]byte("new-password"))
+	if strings.Contains(hashed.String(), hex.EncodeToString(sum[:])[:12]) {
+		t.Fatalf("Values shouldn't be hashed without key, got '%s'", hashed.String())
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	k8sCoreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedCoreV1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// Reasons of k8s Events
+const (
+	eventCreated                = "Created"
+	eventUpdated                = "Updated"
+	eventRecreated              = "Recreated"
+	eventSkippedUnmanaged       = "SkippedUnmanaged"
+	eventAnnotationPathMismatch = "AnnotationPathMismatch"
+	eventInvalidData            = "InvalidData"
+	eventSyncFailed             = "SyncFailed"
+	eventSynced                 = "Synced"
+	eventRolledOut              = "RolledOut"
+)
+
+// Create recorder which sends k8s Events to API server
+func newEventRecorder(k8sClient kubernetes.Interface) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedCoreV1.EventSinkImpl{Interface: k8sClient.CoreV1().Events("")})
+
+	return broadcaster.NewRecorder(scheme.Scheme, k8sCoreV1.EventSource{Component: appName})
+}
+
+// Record k8s Event for object, Events aren't recorded if they are disabled or k8s objects aren't changed
+func (d *vtkData) recordEvent(object runtime.Object, eventType, reason, message string) {
+	if d.eventRecorder == nil || d.readOnly() {
+		return
+	}
+	d.eventRecorder.Event(object, eventType, reason, message)
+}
+
+// Record k8s Event for namespace, it's used when k8s object doesn't exist
+func (d *vtkData) recordNamespaceEvent(namespace, eventType, reason, message string) {
+	// Event is created in the same namespace, so it's listed by 'kubectl get events -n <namespace>'
+	ref := &k8sCoreV1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Namespace",
+		Name:       namespace,
+		Namespace:  namespace,
+	}
+	d.recordEvent(ref, eventType, reason, message)
+}
+
+// Reason of Event for k8s object which was skipped
+func (d *vtkData) skipEventReason(annotations map[string]string) string {
+	if _, ok := annotations[d.annotationName()]; !ok {
+		return eventSkippedUnmanaged
+	}
+
+	return eventAnnotationPathMismatch
+}
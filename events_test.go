@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	k8sCoreV1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// Test k8s Events which are recorded for created, updated, skipped and invalid secrets
+func TestUpdateSecretInK8sEvents(t *testing.T) {
+	d := &vtkData{}
+	tvsd := d.testVaultServer(t)
+	defer tvsd.server.Close()
+	_ = d.testK8sServer(t)
+	defer defineAppInitParams()
+	recorder := record.NewFakeRecorder(10)
+	d.eventRecorder = recorder
+
+	d.testVaultServerCreateSecrets(t, []string{"app-new", "app-unmanaged", "app-other", "app-update"}, "k8s-ns1")
+	d.testVaultServerCreateSecretsIncorrectData(t, []string{"app-invalid"}, "k8s-ns1")
+	existingSecrets := map[string]map[string]string{
+		"app-unmanaged-v1": {},
+		"app-other-v1":     {annotationName: vaultSecretsPath + "/k8s-ns1/app-new"},
+		"app-update-v1":    {annotationName: vaultSecretsPath + "/k8s-ns1/app-update"},
+	}
+	for name, annotations := range existingSecrets {
+		secret := &k8sCoreV1.Secret{}
+		secret.Name = name
+		secret.Annotations = annotations
+		if _, err := d.k8sClient.CoreV1().Secrets("k8s-ns1").Create(secret); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := map[string]string{
+		"app-new":       k8sCoreV1.EventTypeNormal + " " + eventCreated,
+		"app-unmanaged": k8sCoreV1.EventTypeWarning + " " + eventSkippedUnmanaged,
+		"app-other":     k8sCoreV1.EventTypeWarning + " " + eventAnnotationPathMismatch,
+		"app-update":    k8sCoreV1.EventTypeNormal + " " + eventUpdated,
+		"app-invalid":   k8sCoreV1.EventTypeWarning + " " + eventInvalidData,
+	}
+	for name, event := range expected {
+		results := d.updateSecretInK8s("", secretForUpdate{name: name, versioning: 1}, "k8s-ns1", "k8s-ns1", "."+k8sClusterName, []string{}, []string{})
+		if results.err != nil {
+			t.Fatal(results.err)
+		}
+		select {
+		case e := <-recorder.Events:
+			if !strings.HasPrefix(e, event+" ") || !strings.Contains(e, "/k8s-ns1/"+name) {
+				t.Fatalf("Expected event '%s' for '%s', got '%s'", event, name, e)
+			}
+		default:
+			t.Fatalf("Expected event '%s' for '%s'", event, name)
+		}
+	}
+
+	// Events aren't recorded in dry-run mode
+	dryRun = "true"
+	d.testVaultServerCreateSecrets(t, []string{"app-dry-run"}, "k8s-ns1")
+	if results := d.updateSecretInK8s("", secretForUpdate{name: "app-dry-run", versioning: 1}, "k8s-ns1", "k8s-ns1", "."+k8sClusterName, []string{}, []string{}); results.err != nil {
+		t.Fatal(results.err)
+	}
+	if len(recorder.Events) != 0 {
+		t.Fatalf("Events shouldn't be recorded in dry-run mode, got '%s'", <-recorder.Events)
+	}
+}
@@ -1,20 +1,36 @@
 package main
 
 import (
+	"context"
 	"math"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/golang/glog"
+	vault "github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
 	dto "github.com/prometheus/client_model/go"
+	k8sApiErr "k8s.io/apimachinery/pkg/api/errors"
 )
 
 const (
 	namespace = "vtk"
 )
 
+// Backends of API requests
+const (
+	backendVault = "vault"
+	backendK8s   = "k8s"
+)
+
 var (
+	registerMetricsOnce sync.Once
+
 	syncTime = prometheus.NewGauge(prometheus.GaugeOpts{
 		Namespace: namespace,
 		Name:      "sync_time",
@@ -30,36 +46,244 @@ var (
 		Name:      "sync_status",
 		Help:      "Status of sync",
 	},
-		[]string{"namespace"},
+		[]string{"source", "namespace"},
 	)
 	secretsCreated = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: namespace,
 		Name:      "secrets_created",
 		Help:      "How many secrets were created in k8s during sync cycle",
 	},
-		[]string{"namespace"},
+		[]string{"source", "namespace"},
 	)
 	secretsUpdated = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: namespace,
 		Name:      "secrets_updated",
 		Help:      "How many secrets were updated in k8s during sync cycle",
 	},
-		[]string{"namespace"},
+		[]string{"source", "namespace"},
 	)
 	secretsSkipped = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: namespace,
 		Name:      "secrets_skipped",
 		Help:      "How many secrets were skipped during sync cycle",
 	},
-		[]string{"namespace"},
+		[]string{"source", "namespace"},
 	)
 	secretsSynced = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: namespace,
 		Name:      "secrets_synced",
 		Help:      "How many secrets were synced during sync cycle",
 	},
-		[]string{"namespace"},
+		[]string{"source", "namespace"},
+	)
+	configMapsCreated = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "configmaps_created",
+		Help:      "How many configmaps were created in k8s during sync cycle",
+	},
+		[]string{"source", "namespace"},
+	)
+	configMapsUpdated = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "configmaps_updated",
+		Help:      "How many configmaps were updated in k8s during sync cycle",
+	},
+		[]string{"source", "namespace"},
+	)
+	configMapsSkipped = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "configmaps_skipped",
+		Help:      "How many configmaps were skipped during sync cycle",
+	},
+		[]string{"source", "namespace"},
+	)
+	configMapsSynced = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "configmaps_synced",
+		Help:      "How many configmaps were synced during sync cycle",
+	},
+		[]string{"source", "namespace"},
+	)
+	secretsNonStringValues = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "secrets_non_string_values",
+		Help:      "How many Vault secrets with non-string values were handled by strategy during sync cycle",
+	},
+		[]string{"source", "namespace", "strategy"},
+	)
+	secretNonStringValues = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "secret_non_string_values",
+		Help:      "Vault secret has non-string values which were handled by strategy",
+	},
+		[]string{"source", "namespace", "secret", "strategy"},
+	)
+	namespacesExcluded = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "namespaces_excluded",
+		Help:      "How many k8s namespaces with secrets in Vault were excluded from sync by reason",
+	},
+		[]string{"reason"},
+	)
+	vaultSecrets = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "vaultsecrets",
+		Help:      "How many VaultSecret resources are synced or failed",
+	},
+		[]string{"status"},
+	)
+	dryRunPlan = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "dry_run_plan",
+		Help:      "How many k8s objects would be changed by action in dry-run mode during sync cycle",
+	},
+		[]string{"source", "namespace", "action"},
+	)
+	secretsPruned = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "secrets_pruned",
+		Help:      "How many secrets were pruned in k8s during sync cycle",
+	},
+		[]string{"source", "namespace"},
+	)
+	workloadsRolledOut = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "workloads_rolled_out",
+		Help:      "How many workloads were rolled out in k8s due to change of non-versioning secrets during sync cycle",
+	},
+		[]string{"source", "namespace"},
+	)
+	secretsVersionsDeleted = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "secrets_versions_deleted",
+		Help:      "How many superseded secret versions were deleted in k8s during sync cycle",
+	},
+		[]string{"source", "namespace"},
+	)
+	secretsCreatedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "secrets_created_total",
+		Help:      "How many secrets were created in k8s since application start",
+	},
+		[]string{"source", "namespace"},
+	)
+	secretsUpdatedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "secrets_updated_total",
+		Help:      "How many secrets were updated in k8s since application start",
+	},
+		[]string{"source", "namespace"},
+	)
+	secretsSkippedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "secrets_skipped_total",
+		Help:      "How many secrets were skipped since application start",
+	},
+		[]string{"source", "namespace"},
+	)
+	secretsSyncedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "secrets_synced_total",
+		Help:      "How many times secrets were synced since application start",
+	},
+		[]string{"source", "namespace"},
+	)
+	configMapsCreatedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "configmaps_created_total",
+		Help:      "How many configmaps were created in k8s since application start",
+	},
+		[]string{"source", "namespace"},
+	)
+	configMapsUpdatedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "configmaps_updated_total",
+		Help:      "How many configmaps were updated in k8s since application start",
+	},
+		[]string{"source", "namespace"},
+	)
+	configMapsSkippedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "configmaps_skipped_total",
+		Help:      "How many configmaps were skipped since application start",
+	},
+		[]string{"source", "namespace"},
+	)
+	configMapsSyncedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "configmaps_synced_total",
+		Help:      "How many times configmaps were synced since application start",
+	},
+		[]string{"source", "namespace"},
+	)
+	secretsNonStringValuesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "secrets_non_string_values_total",
+		Help:      "How many Vault secrets with non-string values were handled by strategy since application start",
+	},
+		[]string{"source", "namespace", "strategy"},
+	)
+	secretsPrunedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "secrets_pruned_total",
+		Help:      "How many secrets were pruned in k8s since application start",
+	},
+		[]string{"source", "namespace"},
+	)
+	secretsVersionsDeletedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "secrets_versions_deleted_total",
+		Help:      "How many superseded secret versions were deleted in k8s since application start",
+	},
+		[]string{"source", "namespace"},
+	)
+	workloadsRolledOutTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "workloads_rolled_out_total",
+		Help:      "How many workloads were rolled out in k8s due to change of non-versioning secrets since application start",
+	},
+		[]string{"source", "namespace"},
+	)
+	syncDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "sync_duration_seconds",
+		Help:      "How long the sync run took",
+		Buckets:   prometheus.ExponentialBuckets(0.5, 2, 12),
+	})
+	lastSuccessfulSync = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_successful_sync_timestamp_seconds",
+		Help:      "Timestamp of the last successful sync of namespace",
+	},
+		[]string{"source", "namespace"},
+	)
+	secretVersion = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "secret_version",
+		Help:      "Version of Vault secret which was synced to k8s",
+	},
+		[]string{"source", "namespace", "secret"},
+	)
+	secretAge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "secret_age_seconds",
+		Help:      "How many seconds passed since creation of synced Vault secret version, refreshed during sync cycle",
+	},
+		[]string{"source", "namespace", "secret"},
+	)
+	apiRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "api_request_duration_seconds",
+		Help:      "Duration of requests to Vault and k8s API",
+		Buckets:   prometheus.DefBuckets,
+	},
+		[]string{"backend", "operation", "status"},
 	)
+	leader = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "leader",
+		Help:      "Whether application replica is the leader which syncs secrets",
+	})
 	authApproleSecretID = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: namespace,
 		Name:      "auth_approle_secret_id",
@@ -76,7 +300,7 @@ var (
 	)
 )
 
-func prometheusMetricsFunc() {
+func prometheusMetricsFunc(ctx context.Context) {
 	http.Handle(prometheusMetricsPath, promhttp.Handler())
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
@@ -84,26 +308,173 @@ func prometheusMetricsFunc() {
 			<body>
 			<h1>Vault to K8s Prometheus Exporter</h1>
 			<p><a href="` + prometheusMetricsPath + `">Metrics</a></p>
+			<p><a href="/healthz">Health</a></p>
+			<p><a href="/readyz">Readiness</a></p>
 			</body>
 			</html>`))
 	})
+	http.HandleFunc("/healthz", healthzHandler)
+	http.HandleFunc("/readyz", readyzHandler)
 
-	prometheus.MustRegister(syncTime)
-	prometheus.MustRegister(syncCount)
-	prometheus.MustRegister(syncStatus)
-	prometheus.MustRegister(secretsCreated)
-	prometheus.MustRegister(secretsUpdated)
-	prometheus.MustRegister(secretsSkipped)
-	prometheus.MustRegister(secretsSynced)
-	prometheus.MustRegister(authApproleSecretID)
-	prometheus.MustRegister(authToken)
+	registerMetrics()
 
 	glog.Infoln("Prometheus exporter enabled")
 	glog.Infoln("Prometheus exporter metrics path", prometheusMetricsPath)
 	glog.Infoln("Prometheus exporter listening on", prometheusListenAddress)
-	if err := http.ListenAndServe(prometheusListenAddress, nil); err != nil {
+
+	// Stop server when application is stopping
+	server := &http.Server{Addr: prometheusListenAddress}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			glog.Errorln(err)
+		}
+	}()
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		glog.Fatal(err)
 	}
+	glog.Infoln("Prometheus exporter stopped")
+}
+
+// Register metrics in default registry, it's used by exporter and for push to Pushgateway
+func registerMetrics() {
+	registerMetricsOnce.Do(func() {
+		prometheus.MustRegister(syncTime)
+		prometheus.MustRegister(syncCount)
+		prometheus.MustRegister(syncStatus)
+		prometheus.MustRegister(secretsCreated)
+		prometheus.MustRegister(secretsUpdated)
+		prometheus.MustRegister(secretsSkipped)
+		prometheus.MustRegister(secretsSynced)
+		prometheus.MustRegister(configMapsCreated)
+		prometheus.MustRegister(configMapsUpdated)
+		prometheus.MustRegister(configMapsSkipped)
+		prometheus.MustRegister(configMapsSynced)
+		prometheus.MustRegister(secretsNonStringValues)
+		prometheus.MustRegister(secretNonStringValues)
+		prometheus.MustRegister(namespacesExcluded)
+		prometheus.MustRegister(vaultSecrets)
+		prometheus.MustRegister(dryRunPlan)
+		prometheus.MustRegister(secretsPruned)
+		prometheus.MustRegister(secretsVersionsDeleted)
+		prometheus.MustRegister(workloadsRolledOut)
+		prometheus.MustRegister(secretsCreatedTotal)
+		prometheus.MustRegister(secretsUpdatedTotal)
+		prometheus.MustRegister(secretsSkippedTotal)
+		prometheus.MustRegister(secretsSyncedTotal)
+		prometheus.MustRegister(configMapsCreatedTotal)
+		prometheus.MustRegister(configMapsUpdatedTotal)
+		prometheus.MustRegister(configMapsSkippedTotal)
+		prometheus.MustRegister(configMapsSyncedTotal)
+		prometheus.MustRegister(secretsNonStringValuesTotal)
+		prometheus.MustRegister(secretsPrunedTotal)
+		prometheus.MustRegister(secretsVersionsDeletedTotal)
+		prometheus.MustRegister(workloadsRolledOutTotal)
+		prometheus.MustRegister(syncDuration)
+		prometheus.MustRegister(lastSuccessfulSync)
+		prometheus.MustRegister(secretVersion)
+		prometheus.MustRegister(secretAge)
+		prometheus.MustRegister(apiRequestDuration)
+		prometheus.MustRegister(leader)
+		prometheus.MustRegister(authApproleSecretID)
+		prometheus.MustRegister(authToken)
+	})
+}
+
+// Push metrics to Pushgateway, metrics of previous push of the same job are replaced
+func pushMetrics() error {
+	glog.Infoln("Push metrics to Pushgateway", pushgatewayURL)
+	if err := push.New(pushgatewayURL, appName).Gatherer(prometheus.DefaultGatherer).Push(); err != nil {
+		return errors.Wrap(err, "Error during push metrics to Pushgateway")
+	}
+
+	return nil
+}
+
+// Set per-secret metrics of Vault secrets synced to namespace, metrics of secrets which weren't synced are deleted only after successful sync
+func (d *vtkData) setSecretMetrics(namespace string, synced map[string]updateSecretResults, successful bool, now time.Time) {
+	if d.secretMetrics == nil {
+		d.secretMetrics = make(map[string][]string)
+	}
+	current := []string{}
+	for name, results := range synced {
+		version, err := strconv.ParseFloat(results.version, 64)
+		if err != nil {
+			continue
+		}
+		secretVersion.WithLabelValues(d.sourceName(), namespace, name).Set(version)
+		if createdTime, err := time.Parse(time.RFC3339Nano, results.createdTime); err == nil {
+			secretAge.WithLabelValues(d.sourceName(), namespace, name).Set(now.Sub(createdTime).Seconds())
+		}
+		current = append(current, name)
+	}
+	for _, name := range d.secretMetrics[namespace] {
+		if _, ok := synced[name]; ok {
+			continue
+		}
+		if !successful {
+			current = append(current, name)
+			continue
+		}
+		secretVersion.DeleteLabelValues(d.sourceName(), namespace, name)
+		secretAge.DeleteLabelValues(d.sourceName(), namespace, name)
+	}
+	d.secretMetrics[namespace] = current
+}
+
+// Set metric of Vault secrets with non-string values in namespace. Data of up-to-date secrets isn't read with metadata change detection,
+// therefore metric of such secrets is kept, metrics of secrets which weren't checked are deleted only after successful sync
+func (d *vtkData) setNonStringValuesMetrics(namespace string, checked map[string]updateSecretResults, successful bool) {
+	if d.nonStringSecrets == nil {
+		d.nonStringSecrets = make(map[string][]string)
+	}
+	previous := make(map[string]bool)
+	for _, name := range d.nonStringSecrets[namespace] {
+		previous[name] = true
+	}
+	current := []string{}
+	for name, results := range checked {
+		if results.nonStringValues > 0 || (!results.dataConverted && previous[name]) {
+			secretNonStringValues.WithLabelValues(d.sourceName(), namespace, name, nonStringValues).Set(1)
+			current = append(current, name)
+		}
+	}
+	for name := range previous {
+		if results, ok := checked[name]; ok {
+			if results.nonStringValues == 0 && results.dataConverted {
+				secretNonStringValues.DeleteLabelValues(d.sourceName(), namespace, name, nonStringValues)
+			}
+			continue
+		}
+		if !successful {
+			current = append(current, name)
+			continue
+		}
+		secretNonStringValues.DeleteLabelValues(d.sourceName(), namespace, name, nonStringValues)
+	}
+	d.nonStringSecrets[namespace] = current
+}
+
+// Observe duration of request to Vault or k8s API which was started at 'start'
+func observeAPIRequest(backend, operation string, start time.Time, err error) {
+	apiRequestDuration.WithLabelValues(backend, operation, apiRequestStatus(err)).Observe(time.Since(start).Seconds())
+}
+
+// Status of API request: 'success', HTTP status code of API error or 'error' if request didn't get response
+func apiRequestStatus(err error) string {
+	if err == nil {
+		return "success"
+	}
+	switch e := errors.Cause(err).(type) {
+	case *vault.ResponseError:
+		return strconv.Itoa(e.StatusCode)
+	case k8sApiErr.APIStatus:
+		return strconv.Itoa(int(e.Status().Code))
+	}
+
+	return "error"
 }
 
 // https://github.com/prometheus/client_golang/issues/412
@@ -116,5 +487,8 @@ func readMetricValue(m prometheus.Metric) float64 {
 	if pb.Counter != nil {
 		return pb.Counter.GetValue()
 	}
+	if pb.Histogram != nil {
+		return float64(pb.Histogram.GetSampleCount())
+	}
 	return math.NaN()
 }
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	k8sApiErr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// Test status of API request in metrics
+func TestAPIRequestStatus(t *testing.T) {
+	notFound := k8sApiErr.NewNotFound(schema.GroupResource{Resource: "secrets"}, "secret1")
+	tests := map[string]struct {
+		err    error
+		status string
+	}{
+		"success":         {nil, "success"},
+		"vault error":     {&vault.ResponseError{StatusCode: 403}, "403"},
+		"k8s error":       {notFound, "404"},
+		"wrapped error":   {errors.Wrap(notFound, "Error during get k8s secret"), "404"},
+		"connection lost": {fmt.Errorf("connection refused"), "error"},
+	}
+	for name, tt := range tests {
+		if status := apiRequestStatus(tt.err); status != tt.status {
+			t.Fatalf("%s: expected status '%s', got '%s'", name, tt.status, status)
+		}
+	}
+}
+
+// Test duration of requests to Vault and k8s API is observed
+func TestObserveAPIRequest(t *testing.T) {
+	d := &vtkData{}
+	tvsd := d.testVaultServer(t)
+	defer tvsd.server.Close()
+	_ = d.testK8sServer(t)
+	defer defineAppInitParams()
+
+	read := apiRequestDuration.WithLabelValues(backendVault, "read", "success").(prometheus.Metric)
+	listSecrets := apiRequestDuration.WithLabelValues(backendK8s, "list_secrets", "success").(prometheus.Metric)
+	readCount, listSecretsCount := readMetricValue(read), readMetricValue(listSecrets)
+
+	if _, _, err := d.secretsRead(vaultSecretsPath + "/k8s-ns1/secret1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.k8sSecretsList("k8s-ns1"); err != nil {
+		t.Fatal(err)
+	}
+	if count := readMetricValue(read); count != readCount+1 {
+		t.Fatalf("Expected 1 observed Vault read, got '%v'", count-readCount)
+	}
+	if count := readMetricValue(listSecrets); count != listSecretsCount+1 {
+		t.Fatalf("Expected 1 observed k8s list of secrets, got '%v'", count-listSecretsCount)
+	}
+
+	// Lists of workloads are observed
+	listReplicaSets := apiRequestDuration.WithLabelValues(backendK8s, "list_replicasets", "success").(prometheus.Metric)
+	listReplicaSetsCount := readMetricValue(listReplicaSets)
+	if _, err := d.k8sReferencedSecrets("k8s-ns1"); err != nil {
+		t.Fatal(err)
+	}
+	if count := readMetricValue(listReplicaSets); count != listReplicaSetsCount+1 {
+		t.Fatalf("Expected 1 observed k8s list of replicasets, got '%v'", count-listReplicaSetsCount)
+	}
+
+	// Changed secret is updated by one request
+	d.testVaultServerCreateSecrets(t, []string{"app"}, "k8s-ns1")
+	d.testK8sServerCreateSecret(t, "app", "k8s-ns1", annotationName, vaultSecretsPath+"/k8s-ns1/app")
+	updateSecret := apiRequestDuration.WithLabelValues(backendK8s, "update_secret", "success").(prometheus.Metric)
+	updateSecretCount := readMetricValue(updateSecret)
+	d.k8sClient.(*fake.Clientset).ClearActions()
+	if results := d.updateSecretInK8s("", secretForUpdate{name: "app", versioning: 0}, "k8s-ns1", "k8s-ns1", "."+k8sClusterName, []string{}, []string{}); results.err != nil {
+		t.Fatal(results.err)
+	}
+	updates := 0
+	for _, action := range d.k8sClient.(*fake.Clientset).Actions() {
+		if action.GetVerb() == "update" && action.GetResource().Resource == "secrets" {
+			updates++
+		}
+	}
+	if updates != 1 {
+		t.Fatalf("Expected 1 update of k8s secret, got '%d'", updates)
+	}
+	if count := readMetricValue(updateSecret); count != updateSecretCount+1 {
+		t.Fatalf("Expected 1 observed k8s update of secret, got '%v'", count-updateSecretCount)
+	}
+}
+
+// Test cumulative counters, last successful sync timestamp and per-secret metrics of namespace
+func TestSyncNamespaceMetrics(t *testing.T) {
+	d := &vtkData{}
+	tvsd := d.testVaultServer(t)
+	defer tvsd.server.Close()
+	_ = d.testK8sServer(t)
+	defer defineAppInitParams()
+	perSecretMetrics = "true"
+	numWorkers = 2
+
+	d.testVaultServerCreateSecrets(t, []string{"app"}, "k8s-ns-metrics")
+	if !d.syncNamespace(context.Background(), "k8s-ns-metrics", "k8s-ns-metrics", "."+k8sClusterName) {
+		t.Fatal("Sync should be successful")
+	}
+
+	// Counters are increased by values of sync cycle
+	created := readMetricValue(secretsCreatedTotal.WithLabelValues("", "k8s-ns-metrics"))
+	if created != 1 {
+		t.Fatalf("Expected 1 created secret in total, got '%v'", created)
+	}
+	d.testVaultServerCreateSecrets(t, []string{"app2"}, "k8s-ns-metrics")
+	if !d.syncNamespace(context.Background(), "k8s-ns-metrics", "k8s-ns-metrics", "."+k8sClusterName) {
+		t.Fatal("Sync should be successful")
+	}
+	if created := readMetricValue(secretsCreatedTotal.WithLabelValues("", "k8s-ns-metrics")); created != 2 {
+		t.Fatalf("Expected 2 created secrets in total, got '%v'", created)
+	}
+	if synced := readMetricValue(secretsSyncedTotal.WithLabelValues("", "k8s-ns-metrics")); synced != 3 {
+		t.Fatalf("Expected 3 synced secrets in total, got '%v'", synced)
+	}
+	if timestamp := readMetricValue(lastSuccessfulSync.WithLabelValues("", "k8s-ns-metrics")); timestamp == 0 {
+		t.Fatal("Timestamp of last successful sync should be set")
+	}
+
+	// Per-secret metrics are set for synced versions
+	if version := readMetricValue(secretVersion.WithLabelValues("", "k8s-ns-metrics", "app2")); version != 1 {
+		t.Fatalf("Expected synced version '1' of secret 'app2', got '%v'", version)
+	}
+	if age := readMetricValue(secretAge.WithLabelValues("", "k8s-ns-metrics", "app2")); age < 0 || age > 60 {
+		t.Fatalf("Incorrect age of secret 'app2': '%v'", age)
+	}
+
+	// Metrics of secrets which weren't synced are kept after unsuccessful sync and deleted after successful sync
+	synced := map[string]updateSecretResults{"app": {secret: "app", version: "2"}}
+	d.setSecretMetrics("k8s-ns-metrics", synced, false, time.Now())
+	if !checkItemInArray(d.secretMetrics["k8s-ns-metrics"], "app2") {
+		t.Fatal("Metrics of secret 'app2' shouldn't be deleted after unsuccessful sync")
+	}
+	d.setSecretMetrics("k8s-ns-metrics", synced, true, time.Now())
+	if secretVersion.DeleteLabelValues("", "k8s-ns-metrics", "app2") {
+		t.Fatal("Metrics of secret 'app2' should be deleted after successful sync")
+	}
+	if version := readMetricValue(secretVersion.WithLabelValues("", "k8s-ns-metrics", "app")); version != 2 {
+		t.Fatalf("Expected synced version '2' of secret 'app', got '%v'", version)
+	}
+
+	// Metrics of the same secret from other source aren't changed or deleted
+	prod := &vtkData{source: &syncSource{Name: "prod"}}
+	prod.setSecretMetrics("k8s-ns-metrics", map[string]updateSecretResults{"app": {secret: "app", version: "5"}}, true, time.Now())
+	d.setSecretMetrics("k8s-ns-metrics", map[string]updateSecretResults{}, true, time.Now())
+	if version := readMetricValue(secretVersion.WithLabelValues("prod", "k8s-ns-metrics", "app")); version != 5 {
+		t.Fatalf("Expected synced version '5' of secret 'app' from 'prod' source, got '%v'", version)
+	}
+	if secretVersion.DeleteLabelValues("", "k8s-ns-metrics", "app") {
+		t.Fatal("Metrics of secret 'app' from default source should be deleted after its successful sync")
+	}
+}
+
+// Test metric of Vault secret with non-string values is kept while secret is up-to-date and deleted when values are fixed
+func TestSyncNamespaceNonStringValuesMetrics(t *testing.T) {
+	d := &vtkData{}
+	tvsd := d.testVaultServer(t)
+	defer tvsd.server.Close()
+	_ = d.testK8sServer(t)
+	defer defineAppInitParams()
+	nonStringValues = "json"
+	metadataChangeDetection = "true"
+	numWorkers = 2
+
+	d.testVaultServerCreateSecretsIncorrectData(t, []string{"app"}, "k8s-ns-non-string")
+	d.testVaultServerCreateSecrets(t, []string{"app2"}, "k8s-ns-non-string")
+	for i := 0; i < 2; i++ {
+		if !d.syncNamespace(context.Background(), "k8s-ns-non-string", "k8s-ns-non-string", "."+k8sClusterName) {
+			t.Fatal("Sync should be successful")
+		}
+		// Data of secret isn't read in the second sync as it's up-to-date
+		if value := readMetricValue(secretNonStringValues.WithLabelValues("", "k8s-ns-non-string", "app", "json")); value != 1 {
+			t.Fatalf("Expected secret 'app' with non-string values handled by 'json' strategy, got '%v'", value)
+		}
+	}
+	if checkItemInArray(d.nonStringSecrets["k8s-ns-non-string"], "app2") {
+		t.Fatal("Secret 'app2' with string values shouldn't be reported")
+	}
+
+	// Metric is deleted when secret doesn't have non-string values anymore
+	d.testVaultServerCreateSecrets(t, []string{"app"}, "k8s-ns-non-string")
+	if !d.syncNamespace(context.Background(), "k8s-ns-non-string", "k8s-ns-non-string", "."+k8sClusterName) {
+		t.Fatal("Sync should be successful")
+	}
+	if secretNonStringValues.DeleteLabelValues("", "k8s-ns-non-string", "app", "json") {
+		t.Fatal("Metric of secret 'app' should be deleted")
+	}
+}
+
+// Test age of secret is counted from creation of synced version both after read of data and after check of metadata only
+func TestSecretAgeCreatedTime(t *testing.T) {
+	d := &vtkData{}
+	tvsd := d.testVaultServer(t)
+	defer tvsd.server.Close()
+	_ = d.testK8sServer(t)
+	defer defineAppInitParams()
+	metadataChangeDetection = "true"
+	perSecretMetrics = "true"
+
+	d.testVaultServerCreateSecrets(t, []string{"app"}, "k8s-ns-age")
+	_, _, createdTime, err := d.secretsReadVersion(vaultSecretsPath + "/k8s-ns-age/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := d.secretsReadMetadata(vaultSecretsPath + "/k8s-ns-age/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.createdTime != createdTime {
+		t.Fatalf("Incorrect creation time '%s' of current version in metadata, expected '%s'", metadata.createdTime, createdTime)
+	}
+
+	// Data is read as k8s secret doesn't exist, then only metadata is checked as k8s secret is up-to-date
+	for _, k8sSecrets := range [][]string{{}, {"app-v1"}} {
+		results := d.updateSecretInK8s("", secretForUpdate{name: "app", versioning: 1}, "k8s-ns-age", "k8s-ns-age", "."+k8sClusterName, k8sSecrets, []string{})
+		if results.err != nil {
+			t.Fatal(results.err)
+		}
+		if results.dataConverted != (len(k8sSecrets) == 0) {
+			t.Fatalf("Data of Vault secret should be read only if k8s secret doesn't exist, k8s secrets '%v'", k8sSecrets)
+		}
+		if results.createdTime != createdTime {
+			t.Fatalf("Incorrect creation time '%s' of synced version with k8s secrets '%v', expected '%s'", results.createdTime, k8sSecrets, createdTime)
+		}
+		created, err := time.Parse(time.RFC3339Nano, createdTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		now := created.Add(time.Minute)
+		d.setSecretMetrics("k8s-ns-age", map[string]updateSecretResults{"app": results}, true, now)
+		if age := readMetricValue(secretAge.WithLabelValues("", "k8s-ns-age", "app")); age != 60 {
+			t.Fatalf("Incorrect age '%v' of secret 'app' with k8s secrets '%v', expected '60'", age, k8sSecrets)
+		}
+	}
+}
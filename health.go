@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// State of application for health and readiness checks
+type healthState struct {
+	mu                     sync.Mutex
+	active                 bool      // Replica authenticates in Vault and syncs secrets (not standby)
+	activeSince            time.Time // Time when replica became active
+	authenticated          bool      // Replica was authenticated in Vault
+	lastCompletedSync      time.Time // Time of the last completed sync cycle, successful or not
+	lastSuccessfulSync     time.Time // Time of the last successful sync cycle
+	lastSyncFailed         bool      // The last completed sync cycle failed
+	tokenExpiration        time.Time // Expiration time of Vault token, zero if token doesn't expire
+	tokenRotationFailed    bool      // The last token rotation was unsuccessful
+	secretIDExpiration     time.Time // Expiration time of AppRole Secret ID, zero if it doesn't expire
+	secretIDRotationFailed bool      // The last Secret ID rotation was unsuccessful
+}
+
+// Health check response
+type healthResponse struct {
+	Status             string   `json:"status"`
+	Reasons            []string `json:"reasons"`
+	LastCompletedSync  string   `json:"last_completed_sync,omitempty"`
+	LastSuccessfulSync string   `json:"last_successful_sync,omitempty"`
+	Standby            bool     `json:"standby,omitempty"`
+}
+
+var health = &healthState{}
+
+// Replica became active (leader or the only replica)
+func (h *healthState) setActive() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.active = true
+	h.activeSince = time.Now()
+}
+
+// Replica was authenticated in Vault
+func (h *healthState) setAuthenticated() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.authenticated = true
+}
+
+// Sync cycle was completed, 'successful' is false if namespaces of some sources weren't listed, failures of separate namespaces don't fail sync cycle
+func (h *healthState) setSyncCompleted(successful bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastCompletedSync = time.Now()
+	h.lastSyncFailed = !successful
+	if successful {
+		h.lastSuccessfulSync = h.lastCompletedSync
+	}
+}
+
+// Set expiration time of Vault token by its TTL
+func (h *healthState) setTokenTTL(creationTime, ttl int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.tokenExpiration = time.Time{}
+	if ttl > 0 {
+		h.tokenExpiration = time.Unix(creationTime+ttl, 0)
+	}
+}
+
+// Set result of the last token rotation
+func (h *healthState) setTokenRotationFailed(failed bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.tokenRotationFailed = failed
+}
+
+// Set expiration time of AppRole Secret ID by its TTL
+func (h *healthState) setSecretIDTTL(creationTime, ttl int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.secretIDExpiration = time.Time{}
+	if ttl > 0 {
+		h.secretIDExpiration = time.Unix(creationTime+ttl, 0)
+	}
+}
+
+// Set result of the last Secret ID rotation
+func (h *healthState) setSecretIDRotationFailed(failed bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.secretIDRotationFailed = failed
+}
+
+// Reasons why application isn't healthy, empty if it's healthy
+func (h *healthState) healthReasons(now time.Time) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	reasons := []string{}
+
+	// Standby replica only waits for leadership
+	if !h.active {
+		return reasons
+	}
+
+	// Failed sync cycles restart application only if they fail longer than a few sync intervals
+	maxSyncAge := time.Second * time.Duration(syncInterval*healthSyncIntervals)
+	lastSync := h.lastSuccessfulSync
+	if lastSync.IsZero() {
+		lastSync = h.activeSince
+	}
+	if now.Sub(lastSync) > maxSyncAge {
+		if h.lastSuccessfulSync.IsZero() {
+			reasons = append(reasons, "no successful sync cycle since start during "+strconv.Itoa(healthSyncIntervals)+" sync intervals")
+		} else {
+			reasons = append(reasons, "no successful sync cycle since "+h.lastSuccessfulSync.UTC().Format(time.RFC3339)+" during "+strconv.Itoa(healthSyncIntervals)+" sync intervals")
+		}
+	}
+	if h.tokenRotationFailed && !h.tokenExpiration.IsZero() && now.After(h.tokenExpiration) {
+		reasons = append(reasons, "token rotation failed and token expired at "+h.tokenExpiration.UTC().Format(time.RFC3339))
+	}
+	if h.secretIDRotationFailed && !h.secretIDExpiration.IsZero() && now.After(h.secretIDExpiration) {
+		reasons = append(reasons, "secret_id rotation failed and secret_id expired at "+h.secretIDExpiration.UTC().Format(time.RFC3339))
+	}
+
+	return reasons
+}
+
+// Reasons why application isn't ready, empty if it's ready
+func (h *healthState) readyReasons(now time.Time) []string {
+	reasons := h.healthReasons(now)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.active && !h.authenticated {
+		reasons = append(reasons, "not authenticated in Vault")
+	}
+	if h.active && h.lastSyncFailed {
+		reasons = append(reasons, "the last sync cycle failed at "+h.lastCompletedSync.UTC().Format(time.RFC3339))
+	}
+
+	return reasons
+}
+
+// Write health check response
+func (h *healthState) writeResponse(w http.ResponseWriter, reasons []string) {
+	response := healthResponse{
+		Status:  "ok",
+		Reasons: reasons,
+	}
+	h.mu.Lock()
+	if !h.lastCompletedSync.IsZero() {
+		response.LastCompletedSync = h.lastCompletedSync.UTC().Format(time.RFC3339)
+	}
+	if !h.lastSuccessfulSync.IsZero() {
+		response.LastSuccessfulSync = h.lastSuccessfulSync.UTC().Format(time.RFC3339)
+	}
+	response.Standby = !h.active
+	h.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(reasons) != 0 {
+		response.Status = "error"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		glog.Errorln(err)
+	}
+}
+
+// Liveness check handler
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	health.writeResponse(w, health.healthReasons(time.Now()))
+}
+
+// Readiness check handler
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	health.writeResponse(w, health.readyReasons(time.Now()))
+}
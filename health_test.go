@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test reasons of unhealthy state
+func TestHealthReasons(t *testing.T) {
+	defer defineAppInitParams()
+	syncInterval = 60
+	h := &healthState{}
+	now := time.Now()
+
+	// Standby replica is always healthy
+	if reasons := h.healthReasons(now.Add(time.Hour)); len(reasons) != 0 {
+		t.Fatalf("Standby replica should be healthy, but got '%v'", reasons)
+	}
+
+	h.setActive()
+	if reasons := h.readyReasons(now); len(reasons) != 1 || !strings.Contains(reasons[0], "not authenticated") {
+		t.Fatalf("Replica shouldn't be ready before authentication, but got '%v'", reasons)
+	}
+	h.setAuthenticated()
+	if reasons := h.readyReasons(now); len(reasons) != 0 {
+		t.Fatalf("Replica should be ready, but got '%v'", reasons)
+	}
+
+	// No successful sync cycle during 3 sync intervals
+	if reasons := h.healthReasons(now.Add(time.Minute * 4)); len(reasons) != 1 || !strings.Contains(reasons[0], "no successful sync cycle since start") {
+		t.Fatalf("Replica should be unhealthy without sync, but got '%v'", reasons)
+	}
+
+	// Failed sync cycle makes replica not ready, and unhealthy only after 3 sync intervals
+	h.setSyncCompleted(false)
+	if reasons := h.healthReasons(now.Add(time.Minute * 2)); len(reasons) != 0 {
+		t.Fatalf("Replica should be healthy during 3 sync intervals, but got '%v'", reasons)
+	}
+	if reasons := h.readyReasons(now); len(reasons) != 1 || !strings.Contains(reasons[0], "the last sync cycle failed") {
+		t.Fatalf("Replica shouldn't be ready after failed sync cycle, but got '%v'", reasons)
+	}
+	if reasons := h.healthReasons(now.Add(time.Minute * 4)); len(reasons) != 1 || !strings.Contains(reasons[0], "no successful sync cycle since start") {
+		t.Fatalf("Replica should be unhealthy if all sync cycles failed, but got '%v'", reasons)
+	}
+	h.setSyncCompleted(true)
+	if reasons := h.readyReasons(now); len(reasons) != 0 {
+		t.Fatalf("Replica should be ready after successful sync, but got '%v'", reasons)
+	}
+	if reasons := h.healthReasons(now.Add(time.Minute * 2)); len(reasons) != 0 {
+		t.Fatalf("Replica should be healthy after sync, but got '%v'", reasons)
+	}
+	h.setSyncCompleted(false)
+	if reasons := h.healthReasons(now.Add(time.Minute * 4)); len(reasons) != 1 || !strings.Contains(reasons[0], "no successful sync cycle since "+h.lastSuccessfulSync.UTC().Format(time.RFC3339)) {
+		t.Fatalf("Replica should be unhealthy without successful sync, but got '%v'", reasons)
+	}
+	h.setSyncCompleted(true)
+
+	// Token is unhealthy only if rotation failed and it expired
+	h.setTokenTTL(now.Unix(), 60)
+	if reasons := h.healthReasons(now.Add(time.Minute * 2)); len(reasons) != 0 {
+		t.Fatalf("Replica should be healthy without failed rotation, but got '%v'", reasons)
+	}
+	h.setTokenRotationFailed(true)
+	if reasons := h.healthReasons(now.Add(time.Second * 30)); len(reasons) != 0 {
+		t.Fatalf("Replica should be healthy before token expiration, but got '%v'", reasons)
+	}
+	if reasons := h.healthReasons(now.Add(time.Minute * 2)); len(reasons) != 1 || !strings.Contains(reasons[0], "token rotation failed") {
+		t.Fatalf("Replica should be unhealthy with expired token, but got '%v'", reasons)
+	}
+	h.setTokenRotationFailed(false)
+
+	// Secret ID without TTL doesn't expire
+	h.setSecretIDTTL(now.Unix(), 0)
+	h.setSecretIDRotationFailed(true)
+	if reasons := h.healthReasons(now.Add(time.Minute * 2)); len(reasons) != 0 {
+		t.Fatalf("Replica should be healthy with non-expiring secret_id, but got '%v'", reasons)
+	}
+	h.setSecretIDTTL(now.Unix(), 60)
+	if reasons := h.healthReasons(now.Add(time.Minute * 2)); len(reasons) != 1 || !strings.Contains(reasons[0], "secret_id rotation failed") {
+		t.Fatalf("Replica should be unhealthy with expired secret_id, but got '%v'", reasons)
+	}
+}
+
+// Test response of health check handler
+func TestHealthzHandler(t *testing.T) {
+	defer defineAppInitParams()
+	defer func() { health = &healthState{} }()
+	syncInterval = 60
+	health = &healthState{}
+	health.setActive()
+	health.activeSince = time.Now().Add(-time.Hour)
+
+	rr := httptest.NewRecorder()
+	healthzHandler(rr, httptest.NewRequest("GET", "/healthz", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Incorrect status code '%d', expected '%d'", rr.Code, http.StatusServiceUnavailable)
+	}
+	response := healthResponse{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Status != "error" || len(response.Reasons) != 1 {
+		t.Fatalf("Incorrect response '%s'", rr.Body.String())
+	}
+
+	health.setSyncCompleted(true)
+	rr = httptest.NewRecorder()
+	healthzHandler(rr, httptest.NewRequest("GET", "/healthz", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Incorrect status code '%d', expected '%d'", rr.Code, http.StatusOK)
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Status != "ok" || response.LastCompletedSync == "" || response.LastSuccessfulSync == "" {
+		t.Fatalf("Incorrect response '%s'", rr.Body.String())
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+	k8sMetaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// Identity of application replica in leader election
+func leaderElectionIdentity() string {
+	identity, err := os.Hostname()
+	if err != nil || identity == "" {
+		glog.Fatal("Can't get hostname for leader election identity: ", err)
+	}
+
+	return identity
+}
+
+// Run sync and credentials rotation only on the leader of application replicas, returns status of the last sync (unsuccessful if leadership was lost)
+func (d *vtkData) runWithLeaderElection(ctx context.Context, identity string, run func(context.Context) bool) bool {
+	// Lease is released only after sync was stopped
+	leCtx, leCancel := context.WithCancel(context.Background())
+	defer leCancel()
+	started := make(chan struct{})
+	runDone := make(chan struct{})
+	lastSyncStatus := true
+	lost := false
+	go func() {
+		select {
+		case <-ctx.Done():
+			// Leader releases lease after the end of sync
+			select {
+			case <-started:
+			default:
+				leCancel()
+			}
+		case <-started:
+		}
+	}()
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: k8sMetaV1.ObjectMeta{
+			Name:      leaderElectionLeaseName,
+			Namespace: podNamespace,
+		},
+		Client: d.k8sClient.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	}
+
+	leader.Set(0)
+	glog.Infoln("Waiting for leadership in '" + podNamespace + "/" + leaderElectionLeaseName + "' lease as '" + identity + "'")
+	leaderelection.RunOrDie(leCtx, leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   time.Second * time.Duration(leaderElectionLeaseDuration),
+		RenewDeadline:   time.Second * time.Duration(leaderElectionRenewDeadline),
+		RetryPeriod:     time.Second * time.Duration(leaderElectionRetryPeriod),
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leadingCtx context.Context) {
+				close(started)
+				glog.Infoln("Became the leader as '" + identity + "'")
+				leader.Set(1)
+				// Sync is stopped on stop of application and on loss of leadership
+				runCtx, runCancel := context.WithCancel(leadingCtx)
+				go func() {
+					select {
+					case <-ctx.Done():
+						runCancel()
+					case <-runCtx.Done():
+					}
+				}()
+				lastSyncStatus = run(runCtx)
+				runCancel()
+				close(runDone)
+				leCancel()
+			},
+			OnStoppedLeading: func() {
+				leader.Set(0)
+				if leCtx.Err() != nil {
+					glog.Infoln("Released leadership as '" + identity + "'")
+					return
+				}
+				glog.Errorln("Lost leadership as '" + identity + "', waiting for the end of sync")
+				lost = true
+			},
+			OnNewLeader: func(currentLeader string) {
+				if currentLeader != identity {
+					glog.Infoln("Current leader is '" + currentLeader + "'")
+				}
+			},
+		},
+	})
+
+	// Wait for the end of sync if replica was the leader
+	select {
+	case <-started:
+		<-runDone
+	default:
+	}
+
+	// Credentials and state of leader can't be reused safely, replica exits and restarts as standby
+	if lost {
+		glog.Errorln("Stopped sync after loss of leadership as '" + identity + "'")
+		return false
+	}
+
+	return lastSyncStatus
+}
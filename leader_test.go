@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	k8sMetaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8sTesting "k8s.io/client-go/testing"
+)
+
+// Test only one replica becomes the leader and releases lease on stop
+func TestRunWithLeaderElection(t *testing.T) {
+	d := &vtkData{}
+	_ = d.testK8sServer(t)
+	defer defineAppInitParams()
+	leaderElectionLeaseName = "vault-to-k8s"
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+
+	leading := make(chan string, 2)
+	run := func(identity string) func(context.Context) bool {
+		return func(ctx context.Context) bool {
+			leading <- identity
+			<-ctx.Done()
+			return false
+		}
+	}
+
+	stopped := make(chan bool)
+	go func() {
+		stopped <- d.runWithLeaderElection(ctx1, "replica-1", run("replica-1"))
+	}()
+	select {
+	case identity := <-leading:
+		if identity != "replica-1" {
+			t.Fatalf("Incorrect leader '%s', expected 'replica-1'", identity)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Replica should become the leader")
+	}
+
+	lease, err := d.k8sClient.CoordinationV1().Leases(podNamespace).Get(leaderElectionLeaseName, k8sMetaV1.GetOptions{})
+	if err != nil {
+		t.Log(err)
+		t.Fatal("Lease should be created")
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != "replica-1" {
+		t.Fatalf("Incorrect holder identity of lease '%v', expected 'replica-1'", lease.Spec.HolderIdentity)
+	}
+	if readMetricValue(leader) != 1 {
+		t.Fatalf("Incorrect value '%f' of leader metric, expected '1'", readMetricValue(leader))
+	}
+
+	// Standby replica shouldn't run sync while lease is held
+	standbyStopped := make(chan bool)
+	go func() {
+		standbyStopped <- d.runWithLeaderElection(ctx2, "replica-2", run("replica-2"))
+	}()
+	select {
+	case identity := <-leading:
+		t.Fatalf("Replica '%s' shouldn't become the leader", identity)
+	case <-time.After(time.Millisecond * 500):
+	}
+
+	// Leader should return status of the last sync and release lease
+	cancel1()
+	select {
+	case status := <-stopped:
+		if status {
+			t.Fatal("Status of the last sync should be returned")
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Leader should be stopped")
+	}
+	lease, err = d.k8sClient.CoordinationV1().Leases(podNamespace).Get(leaderElectionLeaseName, k8sMetaV1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease.Spec.HolderIdentity != nil && *lease.Spec.HolderIdentity == "replica-1" {
+		t.Fatal("Lease should be released")
+	}
+
+	// Standby replica should be stopped without sync
+	cancel2()
+	select {
+	case status := <-standbyStopped:
+		if !status {
+			t.Fatal("Standby replica should be stopped successfully")
+		}
+	case identity := <-leading:
+		// Standby could take over released lease before stop
+		if identity != "replica-2" {
+			t.Fatalf("Incorrect leader '%s', expected 'replica-2'", identity)
+		}
+		<-standbyStopped
+	case <-time.After(time.Second * 5):
+		t.Fatal("Standby replica should be stopped")
+	}
+}
+
+// Test sync is stopped and drained when the leader can't renew lease
+func TestRunWithLeaderElectionLost(t *testing.T) {
+	d := &vtkData{}
+	_ = d.testK8sServer(t)
+	defer defineAppInitParams()
+	leaderElectionLeaseName = "vault-to-k8s"
+	leaderElectionLeaseDuration = 3
+	leaderElectionRenewDeadline = 2
+	leaderElectionRetryPeriod = 1
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Renew of lease fails after the leader was elected
+	renewFailed := make(chan struct{})
+	updateLeasesReactor := func(action k8sTesting.Action) (handled bool, ret runtime.Object, err error) {
+		select {
+		case <-renewFailed:
+			return true, nil, errors.New("update isn't allowed")
+		default:
+			return false, nil, nil
+		}
+	}
+	d.k8sClient.(*fake.Clientset).PrependReactor("update", "leases", updateLeasesReactor)
+
+	leading := make(chan struct{})
+	drained := make(chan struct{})
+	run := func(runCtx context.Context) bool {
+		close(leading)
+		<-runCtx.Done()
+		close(drained)
+		return true
+	}
+
+	stopped := make(chan bool)
+	go func() {
+		stopped <- d.runWithLeaderElection(ctx, "replica-1", run)
+	}()
+	select {
+	case <-leading:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Replica should become the leader")
+	}
+
+	close(renewFailed)
+
+	select {
+	case status := <-stopped:
+		select {
+		case <-drained:
+		default:
+			t.Fatal("Sync should be drained before return")
+		}
+		if status {
+			t.Fatal("Status should be unsuccessful after loss of leadership")
+		}
+	case <-time.After(time.Second * 10):
+		t.Fatal("Sync should be stopped after loss of leadership")
+	}
+	if ctx.Err() != nil {
+		t.Fatal("Context of application shouldn't be canceled")
+	}
+	if readMetricValue(leader) != 0 {
+		t.Fatalf("Incorrect value '%f' of leader metric, expected '0'", readMetricValue(leader))
+	}
+}
@@ -11,7 +11,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path"
 	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -24,8 +26,12 @@ import (
 	k8sCoreV1 "k8s.io/api/core/v1"
 	k8sApiErr "k8s.io/apimachinery/pkg/api/errors"
 	k8sMetaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 )
 
 var (
@@ -39,7 +45,11 @@ var (
 	approleRoleID                   string
 	approleSecretIDWrappedToken     string
 	approleSecretIDWrappedTokenFile string
+	kubernetesRole                  string
+	kubernetesAuthPath              string
+	kubernetesTokenFile             string
 	tokenRotationInterval           int
+	tokenRenewal                    string
 	approleSecretIDRotationInterval int
 	numWorkers                      int
 	syncInterval                    int
@@ -47,6 +57,41 @@ var (
 	vaultSecretsPath                string
 	nonVersioningNamespaces         string
 	annotationName                  string
+	pruneSecrets                    string
+	pruneGracePeriod                int
+	pruneMaxPerNamespace            int
+	versionsRetention               int
+	versionsRetentionNamespaces     string
+	versionsGC                      string
+	versionsGCPeriod                int
+	metadataChangeDetection         string
+	secretsTypes                    string
+	configMaps                      string
+	configMapsPrefix                string
+	secretsMaxDepth                 int
+	secretsPathJoiner               string
+	nonStringValues                 string
+	flattenSeparator                string
+	leaderElection                  string
+	leaderElectionLeaseName         string
+	leaderElectionLeaseDuration     int
+	leaderElectionRenewDeadline     int
+	leaderElectionRetryPeriod       int
+	shutdownTimeout                 int
+	healthSyncIntervals             int
+	dryRun                          string
+	once                            bool
+	configFile                      string
+	namespacesMapping               string
+	namespacesLabelSelector         string
+	namespacesDenyList              string
+	namespacesOptInAnnotation       string
+	events                          string
+	vaultSecretsCRD                 string
+	rollout                         string
+	perSecretMetrics                string
+	vaultSecretsPollInterval        int
+	pushgatewayURL                  string
 	prometheusMetrics               string
 	prometheusListenAddress         string
 	prometheusMetricsPath           string
@@ -54,14 +99,30 @@ var (
 
 // VTK Data
 type vtkData struct {
-	vaultClient                 *vault.Client        // Vault client
-	k8sClient                   kubernetes.Interface // K8s client
-	vaultTokenAccessor          string               // Vault Token Accessor
-	vaultTokenTTL               map[string]int64     // Vault Token TTL
-	approleSecretID             interface{}          // Vault AppRole Secret ID
-	approleName                 string               // Vault AppRole Name
-	approleSecretIDTTL          map[string]int64     // Vault AppRole Secret ID TTL
-	nonVersioningNamespacesList []string             // List of non-versioning namespaces
+	vaultClient                 *vault.Client                // Vault client
+	k8sClient                   kubernetes.Interface         // K8s client
+	dynamicClient               dynamic.Interface            // K8s client for custom resources
+	vaultTokenAccessor          string                       // Vault Token Accessor
+	vaultTokenTTL               map[string]int64             // Vault Token TTL
+	vaultTokenSecret            *vault.Secret                // Vault login response, it's used for renewal of Token
+	approleSecretID             interface{}                  // Vault AppRole Secret ID
+	approleName                 string                       // Vault AppRole Name
+	approleSecretIDTTL          map[string]int64             // Vault AppRole Secret ID TTL
+	nonVersioningNamespacesList []string                     // List of non-versioning namespaces
+	namespacesMapping           []namespaceMappingRule       // Rules of mapping Vault directories to k8s namespaces
+	namespacesSelector          labels.Selector              // Label selector of k8s namespaces which are eligible for sync
+	deniedNamespaces            map[string]bool              // K8s namespaces which are never synced
+	eventRecorder               record.EventRecorder         // Recorder of k8s Events, nil if Events are disabled
+	source                      *syncSource                  // Sync source from configuration file, nil if it's defined by parameters
+	sources                     []*vtkData                   // Data of sync sources from configuration file
+	pruneCandidates             map[string]int64             // Managed k8s secrets without source in Vault and time when they were found
+	versionsRetentionNamespaces map[string]int               // Number of secret versions to keep per namespace
+	kvVersion                   int                          // Version of Vault KV Secrets Engine
+	unreferencedSecrets         map[string]int64             // Superseded k8s secret versions without references and time when they were found
+	secretMetrics               map[string][]string          // Vault secrets with per-secret metrics per k8s namespace of source
+	nonStringSecrets            map[string][]string          // Vault secrets with non-string values per k8s namespace of source
+	plannedVaultSecrets         map[string]vaultSecretStatus // Planned state of VaultSecret resources in dry-run mode, as their status isn't updated
+	planOnly                    bool                         // Only plan actions for all k8s objects without their changes, it's used by 'diff' subcommand
 }
 
 // Secret for update in k8s
@@ -70,13 +131,38 @@ type secretForUpdate struct {
 	versioning int
 }
 
+// Vault secret metadata
+type vaultSecretMetadata struct {
+	version     string // Current version
+	updatedTime string // Time of last update
+	createdTime string // Time of creation of current version
+	deleted     bool   // Current version was deleted or destroyed
+
+	customMetadata map[string]string // Custom metadata of secret
+}
+
 // K8s update secret results
 type updateSecretResults struct {
-	created float64
-	updated float64
-	skipped float64
-	synced  float64
-	err     error
+	created         float64
+	updated         float64
+	skipped         float64
+	synced          float64
+	nonStringValues float64           // Number of Vault secrets with non-string values
+	dataConverted   bool              // Data of Vault secret was read and converted to data of k8s object
+	rolledOut       float64           // Number of workloads rolled out due to change of non-versioning secrets
+	secret          string            // Name of Vault secret, it's set with synced version
+	version         string            // Synced version of Vault secret
+	createdTime     string            // Time of creation of synced version of Vault secret
+	vaultDeleted    string            // Path of Vault secret which doesn't have data (deleted)
+	configMap       bool              // Vault secret was synced to k8s ConfigMap
+	plan            []planEntry       // Planned actions for k8s objects
+	data            map[string][]byte // Converted data of Vault secret
+	err             error
+}
+
+// k8s objects aren't changed in dry-run mode and by 'diff' subcommand
+func (d *vtkData) readOnly() bool {
+	return dryRun == "true" || d.planOnly
 }
 
 // Get 'string' environment variable or return default value
@@ -103,6 +189,20 @@ func getEnvWithDefaultInt(envName string, defValue int) int {
 	return defValue
 }
 
+// Get 'bool' environment variable or return default value
+func getEnvWithDefaultBool(envName string, defValue bool) bool {
+	envValue := os.Getenv(envName)
+	if envValue != "" {
+		envValueBool, err := strconv.ParseBool(envValue)
+		if err != nil {
+			glog.Fatal(err)
+		}
+		return envValueBool
+	}
+
+	return defValue
+}
+
 // Get pod namespace variable
 func getEnvPodNamespace() string {
 	envVal := os.Getenv("POD_NAMESPACE")
@@ -133,7 +233,7 @@ func verifyConfig() error {
 	os.Setenv("VAULT_NAMESPACE", vaultNamespace)
 
 	if authMethod == "" {
-		return fmt.Errorf("You must provide an auth method. Parameter AUTH_METHOD can be \"token\" or \"approle\"")
+		return fmt.Errorf("You must provide an auth method. Parameter AUTH_METHOD can be \"token\", \"approle\" or \"kubernetes\"")
 	}
 	if authMethod == "token" {
 		if vaultToken == "" {
@@ -154,19 +254,76 @@ func verifyConfig() error {
 			}
 			approleSecretIDWrappedToken = string(data)
 		}
+	} else if authMethod == "kubernetes" {
+		if kubernetesRole == "" {
+			return fmt.Errorf("KUBERNETES_ROLE should be defined for \"kubernetes\" auth method")
+		}
+		kubernetesAuthPath = strings.Trim(kubernetesAuthPath, "/")
+		if kubernetesAuthPath == "" {
+			return fmt.Errorf("KUBERNETES_AUTH_PATH should be defined for \"kubernetes\" auth method")
+		}
 	} else {
-		return fmt.Errorf("Incorrect value for AUTH_METHOD, can be \"token\" or \"approle\"")
+		return fmt.Errorf("Incorrect value for AUTH_METHOD, can be \"token\", \"approle\" or \"kubernetes\"")
 	}
 
-	if k8sClusterName == "" {
+	// Sources from configuration file are verified during load
+	if k8sClusterName == "" && configFile == "" {
 		return fmt.Errorf("Must set variable K8S_CLUSTER_NAME")
 	}
 
 	vaultSecretsPath = strings.TrimSuffix(vaultSecretsPath, "/")
-	if vaultSecretsPath == "" {
+	if vaultSecretsPath == "" && configFile == "" {
 		return fmt.Errorf("Must set variable SECRETS_PATH_VAULT")
 	}
 
+	if secretsMaxDepth < 1 {
+		return fmt.Errorf("SECRETS_MAX_DEPTH should be greater than 0")
+	}
+
+	if !regexp.MustCompile(`^[-.a-z0-9]+$`).MatchString(secretsPathJoiner) {
+		return fmt.Errorf("SECRETS_PATH_JOINER can contain only lower case alphanumeric characters, '-' or '.'")
+	}
+
+	if nonStringValues != nonStringValuesSkip && nonStringValues != nonStringValuesJSON && nonStringValues != nonStringValuesFlatten {
+		return fmt.Errorf("Incorrect value for NON_STRING_VALUES, can be \"skip\", \"json\" or \"flatten\"")
+	}
+
+	if !regexp.MustCompile(`^[-._a-zA-Z0-9]+$`).MatchString(flattenSeparator) {
+		return fmt.Errorf("FLATTEN_SEPARATOR can contain only alphanumeric characters, '-', '_' or '.'")
+	}
+
+	if leaderElection == "true" {
+		if leaderElectionLeaseName == "" {
+			leaderElectionLeaseName = appName
+		}
+		if leaderElectionRetryPeriod < 1 {
+			return fmt.Errorf("LEADER_ELECTION_RETRY_PERIOD should be greater than 0")
+		}
+		// Renew deadline should be greater than retry period with jitter (factor 1.2)
+		if leaderElectionRenewDeadline*10 <= leaderElectionRetryPeriod*12 {
+			return fmt.Errorf("LEADER_ELECTION_RENEW_DEADLINE should be greater than 1.2 * LEADER_ELECTION_RETRY_PERIOD")
+		}
+		if leaderElectionLeaseDuration <= leaderElectionRenewDeadline {
+			return fmt.Errorf("LEADER_ELECTION_LEASE_DURATION should be greater than LEADER_ELECTION_RENEW_DEADLINE")
+		}
+	}
+
+	if healthSyncIntervals < 1 {
+		return fmt.Errorf("HEALTH_SYNC_INTERVALS should be greater than 0")
+	}
+
+	if shutdownTimeout < 1 {
+		return fmt.Errorf("SHUTDOWN_TIMEOUT should be greater than 0")
+	}
+
+	if vaultSecretsCRD == "true" && vaultSecretsPollInterval < 1 {
+		return fmt.Errorf("VAULT_SECRETS_POLL_INTERVAL should be greater than 0")
+	}
+
+	if pushgatewayURL != "" && !once {
+		return fmt.Errorf("PUSHGATEWAY_URL can be used only with ONCE")
+	}
+
 	return nil
 }
 
@@ -191,6 +348,66 @@ func structParams() (*vtkData, error) {
 		d.nonVersioningNamespacesList = strings.Split(nonVersioningNamespaces, ",")
 	}
 
+	d.namespacesMapping, err = parseNamespacesMapping(namespacesMapping)
+	if err != nil {
+		return nil, err
+	}
+
+	if namespacesLabelSelector != "" {
+		d.namespacesSelector, err = labels.Parse(namespacesLabelSelector)
+		if err != nil {
+			return nil, errors.Wrap(err, "Incorrect value for NAMESPACES_LABEL_SELECTOR")
+		}
+	}
+	d.deniedNamespaces = make(map[string]bool)
+	if namespacesDenyList != "" {
+		for _, ns := range strings.Split(namespacesDenyList, ",") {
+			d.deniedNamespaces[ns] = true
+		}
+	}
+
+	d.versionsRetentionNamespaces = make(map[string]int)
+	if versionsRetentionNamespaces != "" {
+		for _, nsRetention := range strings.Split(versionsRetentionNamespaces, ",") {
+			nsRetentionParams := strings.SplitN(nsRetention, "=", 2)
+			if len(nsRetentionParams) != 2 {
+				return nil, fmt.Errorf("Incorrect value '%s' in 'SECRETS_VERSIONS_RETENTION_NAMESPACES', should be '<namespace>=<number>'", nsRetention)
+			}
+			retention, err := strconv.Atoi(nsRetentionParams[1])
+			if err != nil || retention < 0 {
+				return nil, fmt.Errorf("Incorrect number of versions '%s' for '%s' namespace in 'SECRETS_VERSIONS_RETENTION_NAMESPACES'", nsRetentionParams[1], nsRetentionParams[0])
+			}
+			d.versionsRetentionNamespaces[nsRetentionParams[0]] = retention
+		}
+	}
+
+	if events == "true" {
+		d.eventRecorder = newEventRecorder(d.k8sClient)
+	}
+
+	// Make k8s client for VaultSecret custom resources
+	if vaultSecretsCRD == "true" {
+		d.dynamicClient, err = newDynamicClient()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Sources of secrets from configuration file
+	if configFile != "" {
+		sources, err := loadSyncConfig(configFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, source := range sources {
+			sourceData, err := d.sourceData(source)
+			if err != nil {
+				return nil, err
+			}
+			d.sources = append(d.sources, sourceData)
+		}
+	}
+
 	return d, nil
 }
 
@@ -223,7 +440,7 @@ func newK8sClient() (*kubernetes.Clientset, error) {
 // Verify if mount exists in Vault and has correct engine type and version
 func (d *vtkData) verifyVaultMount() error {
 	mountNotExists := true
-	vaultMount := strings.SplitN(vaultSecretsPath, "/", 2)[0] + "/"
+	vaultMount := strings.SplitN(d.vaultSecretsPath(), "/", 2)[0] + "/"
 	vaultMountsIn, err := d.vaultClient.Sys().ListMounts()
 	if err != nil {
 		return errors.Wrap(err, "Failed to get list Vault mounts")
@@ -237,10 +454,15 @@ func (d *vtkData) verifyVaultMount() error {
 			return fmt.Errorf("Matching mount '%s' for path '%s' is not of type kv", k, vaultMount)
 		}
 
-		kvVersion, _ := strconv.Atoi(m.Options["version"])
-		if kvVersion != 2 {
-			return fmt.Errorf("Vault mount '%s' and defined path '%s' matched but Vault mount version is not '2'", k, vaultMount)
+		// KV version 1 mount can be without 'version' option
+		kvVersion := 1
+		if m.Options["version"] != "" {
+			kvVersion, _ = strconv.Atoi(m.Options["version"])
+		}
+		if kvVersion != 1 && kvVersion != 2 {
+			return fmt.Errorf("Vault mount '%s' and defined path '%s' matched but Vault mount version is not '1' or '2'", k, vaultMount)
 		}
+		d.kvVersion = kvVersion
 		mountNotExists = false
 	}
 	if mountNotExists {
@@ -250,154 +472,344 @@ func (d *vtkData) verifyVaultMount() error {
 	return nil
 }
 
-// Sync Vault secrets to k8s
-func (d *vtkData) syncVaultToK8s() {
-	k8sClusterNameSuffix := "." + k8sClusterName
+// Sync Vault secrets to k8s until context is canceled, returns status of the last sync
+func (d *vtkData) syncVaultToK8s(ctx context.Context) bool {
+	// First sync starts immediately, next ones each SYNC_INTERVAL
+	lastSyncStatus := d.syncCycle(ctx)
+
+	ticker := time.NewTicker(time.Second * time.Duration(syncInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			glog.Infoln("Sync stopped")
+			return lastSyncStatus
+		case <-ticker.C:
+		}
+		lastSyncStatus = d.syncCycle(ctx)
+	}
+}
 
-	for range time.Tick(time.Second * time.Duration(syncInterval)) {
-		startSync := time.Now()
-		glog.V(2).Infoln()
-		glog.V(2).Infoln("Started sync secrets from Vault to k8s")
+// Sync secrets of all sources once, returns 'true' if sync of all namespaces was successful
+func (d *vtkData) syncCycle(ctx context.Context) bool {
+	startSync := time.Now()
+	glog.V(2).Infoln()
+	glog.V(2).Infoln("Started sync secrets from Vault to k8s")
+
+	syncStatusAll := true
+	listedSources := 0
+	excludedNamespaces := make(map[string]string)
+	for _, source := range d.syncSources() {
+		// Don't start sync of new sources if application is stopping
+		if ctx.Err() != nil {
+			syncStatusAll = false
+			break
+		}
+		if source.sourceName() != "" {
+			glog.V(2).Infoln("Sync '" + source.sourceName() + "' source")
+		}
+		status, listed := source.syncSourceNamespaces(ctx, excludedNamespaces)
+		if !status {
+			syncStatusAll = false
+		}
+		if listed {
+			listedSources++
+		}
+	}
+	// Namespaces excluded for all sources are exposed once per cycle
+	setNamespacesExcluded(excludedNamespaces)
+	if listedSources == 0 {
+		syncTime.Set(float64(0))
+		health.setSyncCompleted(false)
+		return false
+	}
 
-		// Get list of Vault namespaces
-		vaultNamespaces, err := d.vaultNamespacesList()
-		if err != nil {
-			glog.Errorln(err)
-			syncTime.Set(float64(0))
-			syncStatus.WithLabelValues("-").Set(0)
-			continue
+	glog.V(2).Infoln("Finished sync")
+	endSync := time.Since(startSync)
+	glog.V(2).Infoln("Sync time:", endSync)
+	syncTime.Set(float64(endSync))
+	syncDuration.Observe(endSync.Seconds())
+	// Failed namespaces are reported by metrics, so a single broken namespace doesn't fail health checks
+	health.setSyncCompleted(listedSources == len(d.syncSources()))
+	syncCount.Inc()
+	glog.V(2).Infoln("Total number of syncs:", readMetricValue(syncCount))
+
+	return syncStatusAll
+}
+
+// Sync secrets of all namespaces of source, returns 'true' if sync of all namespaces was successful and 'false' as the second value if namespaces for sync weren't listed, namespaces excluded from sync are added to 'excluded'
+func (d *vtkData) syncSourceNamespaces(ctx context.Context, excluded map[string]string) (bool, bool) {
+	k8sClusterNameSuffix := "." + d.k8sClusterName()
+
+	// Get list of Vault namespaces
+	vaultNamespaces, err := d.vaultNamespacesList()
+	if err != nil {
+		glog.Errorln(err)
+		syncStatus.WithLabelValues(d.sourceName(), "-").Set(0)
+		return false, false
+	}
+	if len(vaultNamespaces) == 0 {
+		glog.Warningln("Didn't find any namespaces under secret path:", d.vaultSecretsPath())
+		syncStatus.WithLabelValues(d.sourceName(), "-").Set(0)
+		return false, false
+	}
+	glog.V(2).Infoln("Namespaces in Vault:", vaultNamespaces)
+
+	// Get list of K8s namespaces
+	k8sNamespaces, excludedNamespaces, err := d.k8sNamespacesList()
+	if err != nil {
+		glog.Errorln(err)
+		syncStatus.WithLabelValues(d.sourceName(), "-").Set(0)
+		return false, false
+	}
+	glog.V(2).Infoln("Namespaces in K8s:", k8sNamespaces)
+	glog.V(2).Infoln("Namespaces in K8s excluded from sync:", excludedNamespaces)
+
+	// Get list of namespaces which should be synced
+	nsForSync := d.namespacesForSync(vaultNamespaces, k8sNamespaces, excludedNamespaces, excluded)
+	if len(nsForSync) == 0 {
+		glog.Warningln("There is no namespaces in Vault which exists on current cluster for sync")
+		syncStatus.WithLabelValues(d.sourceName(), "-").Set(0)
+		return false, false
+	}
+	glog.V(2).Infoln("Namespaces for sync:", nsForSync)
+	syncStatus.WithLabelValues(d.sourceName(), "-").Set(1)
+
+	// Sync secrets for each namespace
+	syncStatusAll := true
+	for _, ns := range nsForSync {
+		// Don't start sync of new namespaces if application is stopping
+		if ctx.Err() != nil {
+			syncStatusAll = false
+			break
 		}
-		if len(vaultNamespaces) == 0 {
-			glog.Warningln("Didn't find any namespaces under secret path:", vaultSecretsPath)
-			syncTime.Set(float64(0))
-			syncStatus.WithLabelValues("-").Set(0)
-			continue
+		if !d.syncNamespace(ctx, ns.vaultDir, ns.namespace, k8sClusterNameSuffix) {
+			syncStatusAll = false
 		}
-		glog.V(2).Infoln("Namespaces in Vault:", vaultNamespaces)
+	}
+
+	return syncStatusAll, true
+}
+
+// Sync secrets of Vault directory to k8s namespace, returns 'true' if sync was successful
+func (d *vtkData) syncNamespace(ctx context.Context, vaultDir, namespace, k8sClusterNameSuffix string) bool {
+	syncStatusNamespace := 1.0
+
+	// Get list of Vault secrets
+	secrets, err := d.secretsList(vaultDir)
+	if err != nil {
+		glog.Errorln(err)
+		syncStatus.WithLabelValues(d.sourceName(), namespace).Set(0)
+		return false
+	}
+	glog.V(2).Infoln()
+	glog.V(2).Infoln("Secrets in Vault under '"+vaultDir+"' directory for '"+namespace+"' namespace:", secrets)
+
+	// Filter secrets
+	filteredSecrets := d.filterSecrets(secrets, k8sClusterNameSuffix, namespace)
+	glog.V(2).Infoln("Filtered secrets in Vault under '"+vaultDir+"' directory for '"+namespace+"' namespace:", filteredSecrets)
+
+	// Get list of k8s secrets
+	k8sSecrets, err := d.k8sSecretsList(namespace)
+	if err != nil {
+		glog.Errorln(err)
+		syncStatus.WithLabelValues(d.sourceName(), namespace).Set(0)
+		return false
+	}
+	glog.V(2).Infoln("Secrets in k8s '"+namespace+"' namespace:", k8sSecrets)
 
-		// Get list of K8s namespaces
-		k8sNamespaces, err := d.k8sNamespacesList()
+	// Get list of k8s ConfigMaps
+	k8sConfigMaps := []string{}
+	if configMaps == "true" {
+		k8sConfigMaps, err = d.k8sConfigMapsList(namespace)
 		if err != nil {
 			glog.Errorln(err)
-			syncTime.Set(float64(0))
-			syncStatus.WithLabelValues("-").Set(0)
-			continue
+			syncStatus.WithLabelValues(d.sourceName(), namespace).Set(0)
+			return false
 		}
-		glog.V(2).Infoln("Namespaces in K8s:", k8sNamespaces)
+		glog.V(2).Infoln("ConfigMaps in k8s '"+namespace+"' namespace:", k8sConfigMaps)
+	}
 
-		// Get list of namespaces which should be synced
-		nsForSync := d.namespacesForSync(vaultNamespaces, k8sNamespaces)
-		if len(nsForSync) == 0 {
-			glog.Warningln("There is no namespaces in Vault which exists on current cluster for sync")
-			syncTime.Set(float64(0))
-			syncStatus.WithLabelValues("-").Set(0)
-			continue
-		}
-		glog.V(2).Infoln("Namespaces for sync:", nsForSync)
+	// Create/update secrets in k8s
+	usjc := make(chan secretForUpdate, d.numWorkers())
+	usrc := make(chan updateSecretResults, d.numWorkers())
 
-		// Sync secrets for each namespace
-		for _, namespace := range nsForSync {
-			syncStatusNamespace := 1.0
+	// WaitGroup is used to wait for the program to finish goroutines
+	var wg sync.WaitGroup
+	// Use context for cancelation signal in goroutines if errors occurs or application is stopping
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // Make sure it's called to release resources even if no errors
 
-			// Get list of Vault secrets
-			secrets, err := d.secretsList(namespace)
-			if err != nil {
-				glog.Errorln(err)
-				syncStatus.WithLabelValues(namespace).Set(0)
-				continue
-			}
-			glog.V(2).Infoln()
-			glog.V(2).Infoln("Secrets in Vault under '"+namespace+"' namespace:", secrets)
-
-			// Filter secrets
-			filteredSecrets := d.filterSecrets(secrets, k8sClusterNameSuffix, namespace)
-			glog.V(2).Infoln("Filtered secrets in Vault under '"+namespace+"' namespace:", filteredSecrets)
+	// Create goroutines
+	wg.Add(d.numWorkers())
+	for w := 1; w <= d.numWorkers(); w++ {
+		go d.updateSecretsInK8s(ctx, cancel, w, &wg, usjc, usrc, vaultDir, namespace, k8sClusterNameSuffix, k8sSecrets, k8sConfigMaps)
+	}
 
-			// Get list of k8s secrets
-			k8sSecrets, err := d.k8sSecretsList(namespace)
-			if err != nil {
-				glog.Errorln(err)
-				syncStatus.WithLabelValues(namespace).Set(0)
-				continue
-			}
-			glog.V(2).Infoln("Secrets in k8s '"+namespace+"' namespace:", k8sSecrets)
-
-			// Create/update secrets in k8s
-			usjc := make(chan secretForUpdate, numWorkers)
-			usrc := make(chan updateSecretResults, numWorkers)
-
-			// WaitGroup is used to wait for the program to finish goroutines
-			var wg sync.WaitGroup
-			// Use context for cancelation signal in goroutines if errors occurs
-			_, cancel := context.WithCancel(context.Background())
-			defer cancel() // Make sure it's called to release resources even if no errors
-
-			// Create goroutines
-			wg.Add(numWorkers)
-			for w := 1; w <= numWorkers; w++ {
-				go d.updateSecretsInK8s(cancel, w, &wg, usjc, usrc, namespace, k8sClusterNameSuffix, k8sSecrets)
+	// Send secrets to goroutines
+	go func() {
+		defer close(usjc)
+		for filteredSecret, versioning := range filteredSecrets {
+			select {
+			case usjc <- secretForUpdate{name: filteredSecret, versioning: versioning}:
+			case <-ctx.Done():
+				return
 			}
+		}
+	}()
+
+	// Receive results from goroutines
+	updateResults := &updateSecretResults{
+		created: 0,
+		updated: 0,
+		skipped: 0,
+		synced:  0,
+		err:     nil,
+	}
+	configMapsResults := &updateSecretResults{}
+	vaultDeletedSecrets := []string{}
+	syncedVersions := make(map[string]updateSecretResults)
+	checkedSecrets := make(map[string]updateSecretResults)
+	plan := []planEntry{}
+RESULTS_LOOP:
+	for i := 1; i <= len(filteredSecrets); i++ {
+		var usrcResult updateSecretResults
+		select {
+		case usrcResult = <-usrc:
+		case <-ctx.Done():
+			glog.Warningln("Sync of '" + namespace + "' namespace was interrupted")
+			syncStatusNamespace = 0
+			break RESULTS_LOOP
+		}
+		if usrcResult.err != nil {
+			glog.Errorln(usrcResult.err)
+			syncStatusNamespace = 0
+			break
+		}
+		results := updateResults
+		if usrcResult.configMap {
+			results = configMapsResults
+		}
+		results.created += usrcResult.created
+		results.updated += usrcResult.updated
+		results.skipped += usrcResult.skipped
+		results.synced += usrcResult.synced
+		updateResults.nonStringValues += usrcResult.nonStringValues
+		updateResults.rolledOut += usrcResult.rolledOut
+		if usrcResult.synced > 0 && usrcResult.version != "" {
+			syncedVersions[usrcResult.secret] = usrcResult
+		}
+		if usrcResult.secret != "" {
+			checkedSecrets[usrcResult.secret] = usrcResult
+		}
+		if usrcResult.vaultDeleted != "" {
+			vaultDeletedSecrets = append(vaultDeletedSecrets, usrcResult.vaultDeleted)
+		}
+		plan = append(plan, usrcResult.plan...)
+	}
+	// Stop workers and wait while in-flight secrets are finished
+	cancel()
+	wg.Wait()
+
+	// Only report plan in dry-run mode
+	if dryRun == "true" {
+		if pruneSecrets == "true" || d.versionsRetention(namespace) > 0 || versionsGC == "true" {
+			glog.Infoln("[DRY-RUN] '" + namespace + "' namespace: prune and deletion of old secret versions are skipped")
+		}
+		d.logPlan(namespace, plan)
+		syncStatus.WithLabelValues(d.sourceName(), namespace).Set(syncStatusNamespace)
+		return syncStatusNamespace == 1
+	}
 
-			// Send secrets to goroutines
-			go func() {
-				for filteredSecret, versioning := range filteredSecrets {
-					usjc <- secretForUpdate{name: filteredSecret, versioning: versioning}
-				}
-				close(usjc)
-			}()
-
-			// Receive results from goroutines
-			updateResults := &updateSecretResults{
-				created: 0,
-				updated: 0,
-				skipped: 0,
-				synced:  0,
-				err:     nil,
-			}
-			for i := 1; i <= len(filteredSecrets); i++ {
-				usrcResult := <-usrc
-				if usrcResult.err != nil {
-					wg.Wait()
-					glog.Errorln(usrcResult.err)
-					syncStatusNamespace = 0
-					break
-				}
-				updateResults.created += usrcResult.created
-				updateResults.updated += usrcResult.updated
-				updateResults.skipped += usrcResult.skipped
-				updateResults.synced += usrcResult.synced
-			}
-			close(usrc)
+	// Prune k8s secrets which source was deleted from Vault
+	if pruneSecrets == "true" && syncStatusNamespace == 1 {
+		pruned, err := d.pruneSecretsInK8s(vaultDir, namespace, secrets, vaultDeletedSecrets)
+		if err != nil {
+			glog.Errorln(err)
+			syncStatusNamespace = 0
+		}
+		glog.V(2).Infoln("Pruned secrets:", pruned)
+		secretsPruned.WithLabelValues(d.sourceName(), namespace).Set(pruned)
+		secretsPrunedTotal.WithLabelValues(d.sourceName(), namespace).Add(pruned)
+	}
 
-			glog.V(2).Infoln("Created secrets:", updateResults.created)
-			glog.V(2).Infoln("Updated secrets:", updateResults.updated)
-			glog.V(2).Infoln("Skipped secrets:", updateResults.skipped)
-			glog.V(2).Infoln("Synced secrets:", updateResults.synced)
-			secretsCreated.WithLabelValues(namespace).Set(updateResults.created)
-			secretsUpdated.WithLabelValues(namespace).Set(updateResults.updated)
-			secretsSkipped.WithLabelValues(namespace).Set(updateResults.skipped)
-			secretsSynced.WithLabelValues(namespace).Set(updateResults.synced)
-			syncStatus.WithLabelValues(namespace).Set(syncStatusNamespace)
+	// Delete superseded versions of k8s secrets
+	if (d.versionsRetention(namespace) > 0 || versionsGC == "true") && syncStatusNamespace == 1 {
+		deleted, err := d.deleteOldSecretVersionsInK8s(vaultDir, namespace)
+		if err != nil {
+			glog.Errorln(err)
+			syncStatusNamespace = 0
 		}
+		glog.V(2).Infoln("Deleted old secret versions:", deleted)
+		secretsVersionsDeleted.WithLabelValues(d.sourceName(), namespace).Set(deleted)
+		secretsVersionsDeletedTotal.WithLabelValues(d.sourceName(), namespace).Add(deleted)
+	}
 
-		glog.V(2).Infoln("Finished sync")
-		endSync := time.Since(startSync)
-		glog.V(2).Infoln("Sync time:", endSync)
-		syncTime.Set(float64(endSync))
-		syncStatus.WithLabelValues("-").Set(1)
-		syncCount.Inc()
-		glog.V(2).Infoln("Total number of syncs:", readMetricValue(syncCount))
+	glog.V(2).Infoln("Created secrets:", updateResults.created)
+	glog.V(2).Infoln("Updated secrets:", updateResults.updated)
+	glog.V(2).Infoln("Skipped secrets:", updateResults.skipped)
+	glog.V(2).Infoln("Synced secrets:", updateResults.synced)
+	secretsCreated.WithLabelValues(d.sourceName(), namespace).Set(updateResults.created)
+	secretsUpdated.WithLabelValues(d.sourceName(), namespace).Set(updateResults.updated)
+	secretsSkipped.WithLabelValues(d.sourceName(), namespace).Set(updateResults.skipped)
+	secretsSynced.WithLabelValues(d.sourceName(), namespace).Set(updateResults.synced)
+	secretsCreatedTotal.WithLabelValues(d.sourceName(), namespace).Add(updateResults.created)
+	secretsUpdatedTotal.WithLabelValues(d.sourceName(), namespace).Add(updateResults.updated)
+	secretsSkippedTotal.WithLabelValues(d.sourceName(), namespace).Add(updateResults.skipped)
+	secretsSyncedTotal.WithLabelValues(d.sourceName(), namespace).Add(updateResults.synced)
+	glog.V(2).Infoln("Secrets with non-string values ('"+nonStringValues+"' strategy):", updateResults.nonStringValues)
+	secretsNonStringValues.WithLabelValues(d.sourceName(), namespace, nonStringValues).Set(updateResults.nonStringValues)
+	secretsNonStringValuesTotal.WithLabelValues(d.sourceName(), namespace, nonStringValues).Add(updateResults.nonStringValues)
+	if rollout == "true" {
+		glog.V(2).Infoln("Rolled out workloads:", updateResults.rolledOut)
+		workloadsRolledOut.WithLabelValues(d.sourceName(), namespace).Set(updateResults.rolledOut)
+		workloadsRolledOutTotal.WithLabelValues(d.sourceName(), namespace).Add(updateResults.rolledOut)
+	}
+	if configMaps == "true" {
+		glog.V(2).Infoln("Created configmaps:", configMapsResults.created)
+		glog.V(2).Infoln("Updated configmaps:", configMapsResults.updated)
+		glog.V(2).Infoln("Skipped configmaps:", configMapsResults.skipped)
+		glog.V(2).Infoln("Synced configmaps:", configMapsResults.synced)
+		configMapsCreated.WithLabelValues(d.sourceName(), namespace).Set(configMapsResults.created)
+		configMapsUpdated.WithLabelValues(d.sourceName(), namespace).Set(configMapsResults.updated)
+		configMapsSkipped.WithLabelValues(d.sourceName(), namespace).Set(configMapsResults.skipped)
+		configMapsSynced.WithLabelValues(d.sourceName(), namespace).Set(configMapsResults.synced)
+		configMapsCreatedTotal.WithLabelValues(d.sourceName(), namespace).Add(configMapsResults.created)
+		configMapsUpdatedTotal.WithLabelValues(d.sourceName(), namespace).Add(configMapsResults.updated)
+		configMapsSkippedTotal.WithLabelValues(d.sourceName(), namespace).Add(configMapsResults.skipped)
+		configMapsSyncedTotal.WithLabelValues(d.sourceName(), namespace).Add(configMapsResults.synced)
 	}
+	if perSecretMetrics == "true" {
+		d.setSecretMetrics(namespace, syncedVersions, syncStatusNamespace == 1, time.Now())
+	}
+	d.setNonStringValuesMetrics(namespace, checkedSecrets, syncStatusNamespace == 1)
+	syncStatus.WithLabelValues(d.sourceName(), namespace).Set(syncStatusNamespace)
+	if syncStatusNamespace == 1 {
+		lastSuccessfulSync.WithLabelValues(d.sourceName(), namespace).Set(float64(time.Now().Unix()))
+	}
+
+	return syncStatusNamespace == 1
+}
+
+// Path for Vault API request, KV version 2 has different prefixes for data and metadata
+func (d *vtkData) vaultAPIPath(vaultPath, kvPrefix string) string {
+	if d.kvVersion == 1 {
+		return vaultPath
+	}
+	vaultMount := strings.SplitN(vaultPath, "/", 2)[0]
+	vaultSecretsMount := strings.SplitN(vaultPath, "/", 2)[1]
+
+	return vaultMount + "/" + kvPrefix + "/" + vaultSecretsMount
 }
 
 // List namespaces from Vault
 func (d *vtkData) vaultNamespacesList() ([]string, error) {
-	vaultMount := strings.SplitN(vaultSecretsPath, "/", 2)[0]
-	vaultNamespacesMount := strings.SplitN(vaultSecretsPath, "/", 2)[1]
-	mountPath := vaultMount + "/metadata/" + vaultNamespacesMount
+	mountPath := d.vaultAPIPath(d.vaultSecretsPath(), "metadata")
 
 	// Get mount list from Vault
+	start := time.Now()
 	ml, err := d.vaultClient.Logical().List(mountPath)
+	observeAPIRequest(backendVault, "list", start, err)
 	if err != nil {
 		return nil, err
 	}
@@ -416,48 +828,60 @@ func (d *vtkData) vaultNamespacesList() ([]string, error) {
 	return vaultNamespaces, nil
 }
 
-// List of K8s namespaces
-func (d *vtkData) k8sNamespacesList() ([]string, error) {
+// List of K8s namespaces which are eligible for sync and reasons of exclusion of other namespaces
+func (d *vtkData) k8sNamespacesList() ([]string, map[string]string, error) {
+	start := time.Now()
 	k8sNSObj, err := d.k8sClient.CoreV1().Namespaces().List(k8sMetaV1.ListOptions{})
+	observeAPIRequest(backendK8s, "list_namespaces", start, err)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	k8sNamespaces := []string{}
-	for _, v := range k8sNSObj.Items {
-		k8sNamespaces = append(k8sNamespaces, v.Name)
+	excludedNamespaces := make(map[string]string)
+	for i := range k8sNSObj.Items {
+		if reason := d.namespaceExclusionReason(&k8sNSObj.Items[i]); reason != "" {
+			excludedNamespaces[k8sNSObj.Items[i].Name] = reason
+			continue
+		}
+		k8sNamespaces = append(k8sNamespaces, k8sNSObj.Items[i].Name)
 	}
 
-	return k8sNamespaces, nil
+	return k8sNamespaces, excludedNamespaces, nil
 }
 
-// List of namespaces which should be synced
-func (d *vtkData) namespacesForSync(vaultNamespaces []string, k8sNamespaces []string) []string {
-	nsForSync := []string{}
+// List of Vault directories and k8s namespaces to which they should be synced, namespaces with Vault secrets which are excluded from sync are added to 'excluded'
+func (d *vtkData) namespacesForSync(vaultNamespaces []string, k8sNamespaces []string, excludedNamespaces map[string]string, excluded map[string]string) []namespaceMapping {
+	nsForSync := []namespaceMapping{}
 	k8sNamespacesMap := make(map[string]bool)
 
 	for i := range k8sNamespaces {
 		k8sNamespacesMap[k8sNamespaces[i]] = true
 	}
 
-	for y := range vaultNamespaces {
-		if k8sNamespacesMap[vaultNamespaces[y]] {
-			nsForSync = append(nsForSync, vaultNamespaces[y])
+	for _, ns := range d.mapVaultDirs(vaultNamespaces) {
+		if k8sNamespacesMap[ns.namespace] {
+			nsForSync = append(nsForSync, ns)
+		} else if reason, ok := excludedNamespaces[ns.namespace]; ok {
+			// Excluded namespace isn't a sync failure
+			glog.V(2).Infoln("Namespace '" + ns.namespace + "' is excluded from sync, reason: " + reason)
+			excluded[ns.namespace] = reason
+			syncStatus.DeleteLabelValues(d.sourceName(), ns.namespace)
 		} else {
-			syncStatus.WithLabelValues(vaultNamespaces[y]).Set(0)
+			syncStatus.WithLabelValues(d.sourceName(), ns.namespace).Set(0)
 		}
 	}
 
 	return nsForSync
 }
 
-// List secrets from Vault
-func (d *vtkData) secretsList(namespace string) ([]string, error) {
-	vaultMount := strings.SplitN(vaultSecretsPath, "/", 2)[0]
-	vaultSecretsMount := strings.SplitN(vaultSecretsPath, "/", 2)[1]
-	mountPath := vaultMount + "/metadata/" + vaultSecretsMount + "/" + namespace
+// List secrets from Vault directory
+func (d *vtkData) secretsList(vaultDir string) ([]string, error) {
+	mountPath := d.vaultAPIPath(d.vaultSecretsPath()+"/"+vaultDir, "metadata")
 
 	// Get mount list from Vault
+	start := time.Now()
 	ml, err := d.vaultClient.Logical().List(mountPath)
+	observeAPIRequest(backendVault, "list", start, err)
 	if err != nil {
 		return nil, err
 	}
@@ -466,33 +890,191 @@ func (d *vtkData) secretsList(namespace string) ([]string, error) {
 	}
 
 	// Get secrets list from Vault
+	return d.secretsListDir(mountPath, "", ml.Data["keys"].([]interface{}), 1)
+}
+
+// List secrets from Vault directory and its subdirectories up to 'SECRETS_MAX_DEPTH' level
+func (d *vtkData) secretsListDir(mountPath, vaultDir string, keys []interface{}, depth int) ([]string, error) {
 	vaultSecrets := []string{}
-	for _, v := range ml.Data["keys"].([]interface{}) {
+	for _, v := range keys {
 		if !strings.HasSuffix(v.(string), "/") {
-			vaultSecrets = append(vaultSecrets, v.(string))
+			vaultSecrets = append(vaultSecrets, vaultDir+v.(string))
+			continue
+		}
+		if depth >= secretsMaxDepth {
+			continue
+		}
+
+		vaultSubDir := vaultDir + v.(string)
+		start := time.Now()
+		ml, err := d.vaultClient.Logical().List(mountPath + "/" + strings.TrimSuffix(vaultSubDir, "/"))
+		observeAPIRequest(backendVault, "list", start, err)
+		if err != nil {
+			return nil, err
 		}
+		if ml == nil || ml.Data == nil {
+			continue
+		}
+		vaultSubDirSecrets, err := d.secretsListDir(mountPath, vaultSubDir, ml.Data["keys"].([]interface{}), depth+1)
+		if err != nil {
+			return nil, err
+		}
+		vaultSecrets = append(vaultSecrets, vaultSubDirSecrets...)
 	}
 
 	return vaultSecrets, nil
 }
 
+// Make k8s secret name from Vault secret name (can contain subdirectories)
+func k8sSecretName(vaultSecretName string) string {
+	return strings.Replace(vaultSecretName, "/", secretsPathJoiner, -1)
+}
+
 // Read secrets from Vault
 func (d *vtkData) secretsRead(vaultSecretPath string) (map[string]interface{}, string, error) {
-	vaultMount := strings.SplitN(vaultSecretPath, "/", 2)[0]
-	vaultSecretsMount := strings.SplitN(vaultSecretPath, "/", 2)[1]
-	mountPath := vaultMount + "/data/" + vaultSecretsMount
+	s, secretVersion, _, err := d.secretsReadVersion(vaultSecretPath)
+
+	return s, secretVersion, err
+}
 
+// Read secrets from Vault with version and its creation time
+func (d *vtkData) secretsReadVersion(vaultSecretPath string) (map[string]interface{}, string, string, error) {
+	mountPath := d.vaultAPIPath(vaultSecretPath, "data")
+
+	start := time.Now()
 	s, err := d.vaultClient.Logical().Read(mountPath)
+	observeAPIRequest(backendVault, "read", start, err)
 	if err != nil {
-		return nil, "", err
+		return nil, "", "", err
 	}
+
+	// KV version 1 secrets don't have versions
+	if d.kvVersion == 1 {
+		if s == nil {
+			return nil, "", "", nil
+		}
+		return s.Data, "", "", nil
+	}
+
 	if s == nil || s.Data == nil || s.Data["data"] == nil {
-		return nil, "", nil
+		return nil, "", "", nil
 	}
 
-	secretVersion := fmt.Sprintf("%s", s.Data["metadata"].(map[string]interface{})["version"])
+	versionMetadata := s.Data["metadata"].(map[string]interface{})
+	secretVersion := fmt.Sprintf("%s", versionMetadata["version"])
+	createdTime, _ := versionMetadata["created_time"].(string)
 
-	return s.Data["data"].(map[string]interface{}), secretVersion, nil
+	return s.Data["data"].(map[string]interface{}), secretVersion, createdTime, nil
+}
+
+// Read secret metadata from Vault
+func (d *vtkData) secretsReadMetadata(vaultSecretPath string) (*vaultSecretMetadata, error) {
+	mountPath := d.vaultAPIPath(vaultSecretPath, "metadata")
+
+	start := time.Now()
+	s, err := d.vaultClient.Logical().Read(mountPath)
+	observeAPIRequest(backendVault, "read_metadata", start, err)
+	if err != nil {
+		return nil, err
+	}
+	if s == nil || s.Data == nil || s.Data["current_version"] == nil {
+		return nil, nil
+	}
+
+	metadata := &vaultSecretMetadata{
+		version:     fmt.Sprintf("%s", s.Data["current_version"]),
+		updatedTime: fmt.Sprintf("%s", s.Data["updated_time"]),
+	}
+	if customMetadata, ok := s.Data["custom_metadata"].(map[string]interface{}); ok {
+		metadata.customMetadata = make(map[string]string)
+		for k, v := range customMetadata {
+			metadata.customMetadata[k] = fmt.Sprintf("%v", v)
+		}
+	}
+	if versions, ok := s.Data["versions"].(map[string]interface{}); ok {
+		if currentVersion, ok := versions[metadata.version].(map[string]interface{}); ok {
+			metadata.createdTime, _ = currentVersion["created_time"].(string)
+			if deletionTime, _ := currentVersion["deletion_time"].(string); deletionTime != "" {
+				metadata.deleted = true
+			}
+			if destroyed, _ := currentVersion["destroyed"].(bool); destroyed {
+				metadata.deleted = true
+			}
+		}
+	}
+
+	return metadata, nil
+}
+
+// Annotation name for version of Vault secret
+func (d *vtkData) versionAnnotationName() string {
+	return d.annotationName() + "-version"
+}
+
+// Annotation name for update time of Vault secret
+func (d *vtkData) updatedTimeAnnotationName() string {
+	return d.annotationName() + "-updated-time"
+}
+
+// Check if k8s secrets already have current version of Vault secret
+func (d *vtkData) k8sSecretsUpToDate(namespace, vaultSecretPathFull, k8sClusterNameSuffix string, secretForUpdate secretForUpdate, metadata *vaultSecretMetadata, k8sSecrets []string, configMap bool) (bool, error) {
+	versionExists := false
+	for _, k8sSecret := range k8sSecrets {
+		if k8sSecret == k8sSecretName(secretForUpdate.name)+"-v"+metadata.version {
+			versionExists = true
+			break
+		}
+	}
+	if !versionExists {
+		return false, nil
+	}
+	if secretForUpdate.versioning == 1 {
+		if secretsTypes != "true" || configMap {
+			return true, nil
+		}
+		// Type from custom metadata can be changed without new version, data of existing version is the same as in Vault
+		start := time.Now()
+		existing, err := d.k8sClient.CoreV1().Secrets(namespace).Get(k8sSecretName(secretForUpdate.name)+"-v"+metadata.version, k8sMetaV1.GetOptions{})
+		observeAPIRequest(backendK8s, "get_secret", start, err)
+		if k8sApiErr.IsNotFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, errors.Wrap(err, "Error during get k8s secret")
+		}
+		return secretTypeEqual(existing.Type, getSecretType(metadata.customMetadata, existing.Data)), nil
+	}
+
+	// Non-versioning secret should be updated from the same version
+	var annotations map[string]string
+	nonVersioningName := strings.TrimSuffix(k8sSecretName(secretForUpdate.name), k8sClusterNameSuffix)
+	if configMap {
+		start := time.Now()
+		existing, err := d.k8sClient.CoreV1().ConfigMaps(namespace).Get(nonVersioningName, k8sMetaV1.GetOptions{})
+		observeAPIRequest(backendK8s, "get_configmap", start, err)
+		if k8sApiErr.IsNotFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, errors.Wrap(err, "Error during get k8s config map")
+		}
+		annotations = existing.Annotations
+	} else {
+		start := time.Now()
+		existing, err := d.k8sClient.CoreV1().Secrets(namespace).Get(nonVersioningName, k8sMetaV1.GetOptions{})
+		observeAPIRequest(backendK8s, "get_secret", start, err)
+		if k8sApiErr.IsNotFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, errors.Wrap(err, "Error during get k8s secret")
+		}
+		annotations = existing.Annotations
+	}
+
+	return annotations[d.annotationName()] == vaultSecretPathFull &&
+		annotations[d.versionAnnotationName()] == metadata.version &&
+		annotations[d.updatedTimeAnnotationName()] == metadata.updatedTime, nil
 }
 
 func (d *vtkData) filterSecrets(secrets []string, k8sClusterNameSuffix, namespace string) map[string]int {
@@ -516,8 +1098,10 @@ func (d *vtkData) filterSecrets(secrets []string, k8sClusterNameSuffix, namespac
 			}
 		}
 		if _, ok := filteredSecrets[secret]; !ok {
-			if strings.Contains(secret, ".") {
-				if !strings.HasSuffix(secret, k8sClusterNameSuffix) || strings.Count(secret, ".") > 1 {
+			// Secrets from subdirectories are filtered by name without path
+			secretName := path.Base(secret)
+			if strings.Contains(secretName, ".") {
+				if !strings.HasSuffix(secretName, k8sClusterNameSuffix) || strings.Count(secretName, ".") > 1 {
 					continue
 				}
 			}
@@ -530,7 +1114,9 @@ func (d *vtkData) filterSecrets(secrets []string, k8sClusterNameSuffix, namespac
 
 // List of K8s secrets
 func (d *vtkData) k8sSecretsList(namespace string) ([]string, error) {
+	start := time.Now()
 	k8sNSObj, err := d.k8sClient.CoreV1().Secrets(namespace).List(k8sMetaV1.ListOptions{})
+	observeAPIRequest(backendK8s, "list_secrets", start, err)
 	if err != nil {
 		return nil, err
 	}
@@ -542,144 +1128,350 @@ func (d *vtkData) k8sSecretsList(namespace string) ([]string, error) {
 	return k8sSecrets, nil
 }
 
+// List of k8s secrets managed by application
+func (d *vtkData) k8sManagedSecretsList(namespace string) ([]k8sCoreV1.Secret, error) {
+	start := time.Now()
+	k8sNSObj, err := d.k8sClient.CoreV1().Secrets(namespace).List(k8sMetaV1.ListOptions{})
+	observeAPIRequest(backendK8s, "list_secrets", start, err)
+	if err != nil {
+		return nil, err
+	}
+	k8sSecrets := []k8sCoreV1.Secret{}
+	for _, v := range k8sNSObj.Items {
+		if _, ok := v.Annotations[d.annotationName()]; ok {
+			k8sSecrets = append(k8sSecrets, v)
+		}
+	}
+
+	return k8sSecrets, nil
+}
+
 // Create/update secrets in k8s
-func (d *vtkData) updateSecretsInK8s(cancel context.CancelFunc, numWorker int, wg *sync.WaitGroup, usjc chan secretForUpdate, usrc chan updateSecretResults, namespace, k8sClusterNameSuffix string, k8sSecrets []string) {
+func (d *vtkData) updateSecretsInK8s(ctx context.Context, cancel context.CancelFunc, numWorker int, wg *sync.WaitGroup, usjc chan secretForUpdate, usrc chan updateSecretResults, vaultDir, namespace, k8sClusterNameSuffix string, k8sSecrets, k8sConfigMaps []string) {
 	// Schedule the call to WaitGroup's Done to tell goroutine is completed
 	defer wg.Done()
 
 	// For log messages
 	var numWorkerStr string
-	if numWorkers > 1 {
+	if d.numWorkers() > 1 {
 		numWorkerStr = "[Worker #" + strconv.Itoa(numWorker) + "]: "
 	}
 
-START_LOOP:
-	for secretForUpdate := range usjc {
-		updateResults := &updateSecretResults{
-			created: 0,
-			updated: 0,
-			skipped: 0,
-			synced:  0,
-			err:     nil,
+	for {
+		// Don't take new secrets if sync was canceled
+		var secretForUpdate secretForUpdate
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return
+		case secretForUpdate, ok = <-usjc:
+			if !ok {
+				return
+			}
+		}
+		// Select doesn't prefer canceled context if secret is received at the same time
+		if ctx.Err() != nil {
+			return
 		}
 
-		// Read secrets
-		vaultSecretPathFull := vaultSecretsPath + "/" + namespace + "/" + secretForUpdate.name
-		glog.V(2).Infoln(numWorkerStr + "Read '" + vaultSecretPathFull + "' from Vault")
-		s, v, err := d.secretsRead(vaultSecretPathFull)
-		if err != nil {
-			updateResults.err = errors.Wrap(err, "Error during read Vault secret")
-			usrc <- *updateResults
+		updateResults := d.updateSecretInK8s(numWorkerStr, secretForUpdate, vaultDir, namespace, k8sClusterNameSuffix, k8sSecrets, k8sConfigMaps)
+		select {
+		case usrc <- updateResults:
+		case <-ctx.Done():
+			return
+		}
+		if updateResults.err != nil {
 			cancel()
 			return
 		}
-		if len(s) == 0 {
-			glog.V(2).Infoln(numWorkerStr+"Didn't get any data for secret:", vaultSecretPathFull, ", skipped")
+	}
+}
+
+// Create/update k8s secrets for one Vault secret
+func (d *vtkData) updateSecretInK8s(numWorkerStr string, secretForUpdate secretForUpdate, vaultDir, namespace, k8sClusterNameSuffix string, k8sSecrets, k8sConfigMaps []string) updateSecretResults {
+	updateResults := &updateSecretResults{
+		created: 0,
+		updated: 0,
+		skipped: 0,
+		synced:  0,
+		err:     nil,
+	}
+
+	vaultSecretPathFull := d.vaultSecretsPath() + "/" + vaultDir + "/" + secretForUpdate.name
+
+	// Read secret metadata, it's used for change detection and for getting kind and type of k8s object
+	var metadata *vaultSecretMetadata
+	var customMetadata map[string]string
+	if (metadataChangeDetection == "true" || secretsTypes == "true" || configMaps == "true") && d.kvVersion != 1 {
+		glog.V(2).Infoln(numWorkerStr + "Read metadata of '" + vaultSecretPathFull + "' from Vault")
+		m, err := d.secretsReadMetadata(vaultSecretPathFull)
+		if err != nil {
+			updateResults.err = errors.Wrap(err, "Error during read Vault secret metadata")
+			d.recordNamespaceEvent(namespace, k8sCoreV1.EventTypeWarning, eventSyncFailed, "Failed to read metadata of Vault secret '"+vaultSecretPathFull+"'")
+			return *updateResults
+		}
+		if m != nil {
+			customMetadata = m.customMetadata
+		}
+		metadata = m
+	}
+
+	// Sync Vault secret to k8s ConfigMap or secret
+	k8sObjects := k8sSecrets
+	if isConfigMap(secretForUpdate.name, customMetadata) {
+		updateResults.configMap = true
+		k8sObjects = k8sConfigMaps
+	}
+
+	// Skip read of data if k8s objects are up-to-date
+	if metadataChangeDetection == "true" && d.kvVersion != 1 && !d.planOnly {
+		if metadata == nil || metadata.deleted {
+			glog.V(2).Infoln(numWorkerStr+"Current version of secret was deleted:", vaultSecretPathFull, ", skipped")
 			updateResults.skipped++
-			usrc <- *updateResults
-			continue
+			updateResults.addPlan("", vaultSecretPathFull, actionSkip, "current version of Vault secret was deleted")
+			updateResults.vaultDeleted = vaultSecretPathFull
+			return *updateResults
 		}
-		// Convert data (should be base64 encoded in k8s)
-		data := make(map[string][]byte)
-		for k, v := range s {
-			if reflect.ValueOf(v).Kind() != reflect.String {
-				glog.V(2).Infoln(numWorkerStr+"Incorrect data in secret:", vaultSecretPathFull, ", skipped")
-				updateResults.skipped++
-				usrc <- *updateResults
-				continue START_LOOP
+		upToDate, err := d.k8sSecretsUpToDate(namespace, vaultSecretPathFull, k8sClusterNameSuffix, secretForUpdate, metadata, k8sObjects, updateResults.configMap)
+		if err != nil {
+			// Data of secret is read and k8s objects are checked again below
+			glog.Warningln(numWorkerStr+"Error during check if secret '"+vaultSecretPathFull+"' is up-to-date in '"+namespace+"' namespace:", err)
+		}
+		if upToDate {
+			glog.V(2).Infoln(numWorkerStr + "Ignoring secret '" + vaultSecretPathFull + "' as version '" + metadata.version + "' already synced to '" + namespace + "' namespace")
+			updateResults.secret, updateResults.version, updateResults.createdTime = secretForUpdate.name, metadata.version, metadata.createdTime
+			updateResults.addPlan("", vaultSecretPathFull, actionNone, "version '"+metadata.version+"' already synced")
+			updateResults.synced++
+			if secretForUpdate.versioning == 0 {
+				updateResults.synced++
 			}
-			data[k] = []byte(v.(string))
-		}
-
-		// Make k8s secret name
-		k8sSecretsForUpdate := make(map[string]int)
-		k8sSecretsForUpdate[secretForUpdate.name+"-v"+v] = 1
-		if secretForUpdate.versioning == 0 {
-			k8sSecretsForUpdate[strings.TrimSuffix(secretForUpdate.name, k8sClusterNameSuffix)] = 0
-		}
-		glog.V(2).Infoln(numWorkerStr+"Secrets that need to check before create/update:", k8sSecretsForUpdate)
-
-		// Verify if we should update versioning secrets in k8s
-		for k8sSecret, k8sSecretVersioning := range k8sSecretsForUpdate {
-			if k8sSecretVersioning == 1 {
-				for y := range k8sSecrets {
-					if k8sSecret == k8sSecrets[y] {
-						delete(k8sSecretsForUpdate, k8sSecret)
-						glog.V(2).Infoln(numWorkerStr + "Ignoring secret '" + k8sSecret + "' as it already exists in '" + namespace + "' namespace")
-						updateResults.synced++
-						break
-					}
+			return *updateResults
+		}
+	} else {
+		// Metadata is used for change detection only if it's enabled
+		metadata = nil
+	}
+
+	// Read secrets
+	glog.V(2).Infoln(numWorkerStr + "Read '" + vaultSecretPathFull + "' from Vault")
+	s, v, createdTime, err := d.secretsReadVersion(vaultSecretPathFull)
+	if err != nil {
+		updateResults.err = errors.Wrap(err, "Error during read Vault secret")
+		d.recordNamespaceEvent(namespace, k8sCoreV1.EventTypeWarning, eventSyncFailed, "Failed to read Vault secret '"+vaultSecretPathFull+"'")
+		return *updateResults
+	}
+	updateResults.secret, updateResults.version, updateResults.createdTime = secretForUpdate.name, v, createdTime
+	if len(s) == 0 {
+		glog.V(2).Infoln(numWorkerStr+"Didn't get any data for secret:", vaultSecretPathFull, ", skipped")
+		updateResults.skipped++
+		updateResults.addPlan("", vaultSecretPathFull, actionSkip, "Vault secret doesn't have data")
+		updateResults.vaultDeleted = vaultSecretPathFull
+		return *updateResults
+	}
+	// Convert data (should be base64 encoded in k8s)
+	data, nonString, err := convertSecretData(s)
+	updateResults.dataConverted = true
+	if nonString {
+		glog.Warningln(numWorkerStr + "Secret '" + vaultSecretPathFull + "' for '" + namespace + "' namespace has non-string values, used '" + nonStringValues + "' strategy")
+		updateResults.nonStringValues++
+	}
+	if err != nil {
+		glog.V(2).Infoln(numWorkerStr+"Incorrect data in secret:", vaultSecretPathFull, ", skipped:", err)
+		updateResults.skipped++
+		updateResults.addPlan("", vaultSecretPathFull, actionSkip, "incorrect data: "+err.Error())
+		d.recordNamespaceEvent(namespace, k8sCoreV1.EventTypeWarning, eventInvalidData, "Vault secret '"+vaultSecretPathFull+"' is skipped as it has incorrect data: "+err.Error())
+		return *updateResults
+	}
+	// Results of sync don't keep values of secrets, data is returned only for comparison by 'diff' subcommand
+	if d.planOnly {
+		updateResults.data = data
+	}
+
+	// Get type of k8s secret
+	var secretType k8sCoreV1.SecretType
+	if secretsTypes == "true" && !updateResults.configMap {
+		secretType = getSecretType(customMetadata, data)
+		if err := verifySecretType(secretType, data); err != nil {
+			glog.V(2).Infoln(numWorkerStr+"WARNING: Ignoring Vault secret '"+vaultSecretPathFull+"' as its data doesn't match '"+string(secretType)+"' type of k8s secret:", err)
+			updateResults.skipped++
+			updateResults.addPlan("", vaultSecretPathFull, actionSkip, "data doesn't match '"+string(secretType)+"' type: "+err.Error())
+			d.recordNamespaceEvent(namespace, k8sCoreV1.EventTypeWarning, eventInvalidData, "Vault secret '"+vaultSecretPathFull+"' is skipped as its data doesn't match '"+string(secretType)+"' type: "+err.Error())
+			return *updateResults
+		}
+	}
+
+	// Make k8s secret name
+	secretName := k8sSecretName(secretForUpdate.name)
+	k8sSecretsForUpdate := make(map[string]int)
+	if v != "" {
+		k8sSecretsForUpdate[secretName+"-v"+v] = 1
+	} else if secretForUpdate.versioning == 1 {
+		// KV version 1 secrets don't have versions, therefore always synced without version in name
+		k8sSecretsForUpdate[secretName] = 0
+	}
+	if secretForUpdate.versioning == 0 {
+		k8sSecretsForUpdate[strings.TrimSuffix(secretName, k8sClusterNameSuffix)] = 0
+	}
+	for k8sSecret := range k8sSecretsForUpdate {
+		if errs := validation.IsDNS1123Subdomain(k8sSecret); errs != nil {
+			glog.V(2).Infoln(numWorkerStr+"WARNING: Ignoring k8s secret '"+k8sSecret+"' for Vault secret '"+vaultSecretPathFull+"' as its name isn't valid:", strings.Join(errs, ","))
+			delete(k8sSecretsForUpdate, k8sSecret)
+			updateResults.skipped++
+			updateResults.addPlan(k8sSecret, vaultSecretPathFull, actionSkip, "name isn't valid: "+strings.Join(errs, ","))
+			d.recordNamespaceEvent(namespace, k8sCoreV1.EventTypeWarning, eventInvalidData, "Name '"+k8sSecret+"' for Vault secret '"+vaultSecretPathFull+"' isn't valid: "+strings.Join(errs, ","))
+		}
+	}
+	glog.V(2).Infoln(numWorkerStr+"Secrets that need to check before create/update:", k8sSecretsForUpdate)
+
+	// Verify if we should update versioning secrets in k8s, type of existing version can be changed by custom metadata, therefore it's compared below
+	for k8sSecret, k8sSecretVersioning := range k8sSecretsForUpdate {
+		if k8sSecretVersioning == 1 && (secretsTypes != "true" || updateResults.configMap) {
+			for y := range k8sObjects {
+				if k8sSecret == k8sObjects[y] {
+					delete(k8sSecretsForUpdate, k8sSecret)
+					glog.V(2).Infoln(numWorkerStr + "Ignoring secret '" + k8sSecret + "' as it already exists in '" + namespace + "' namespace")
+					updateResults.synced++
+					updateResults.addPlan(k8sSecret, vaultSecretPathFull, actionNone, "version already exists")
+					break
 				}
 			}
 		}
-		glog.V(2).Infoln(numWorkerStr+"Secrets that can be created/updated:", k8sSecretsForUpdate)
-		if len(k8sSecretsForUpdate) == 0 {
-			usrc <- *updateResults
-			continue
+	}
+	glog.V(2).Infoln(numWorkerStr+"Secrets that can be created/updated:", k8sSecretsForUpdate)
+	if len(k8sSecretsForUpdate) == 0 {
+		return *updateResults
+	}
+
+	// Create/update ConfigMaps in k8s
+	annotations := make(map[string]string)
+	annotations[d.annotationName()] = vaultSecretPathFull
+	if v != "" {
+		annotations[d.versionAnnotationName()] = v
+	}
+	if metadata != nil {
+		annotations[d.updatedTimeAnnotationName()] = metadata.updatedTime
+	}
+	if updateResults.configMap {
+		configMapData := make(map[string]string)
+		for k, v := range data {
+			configMapData[k] = string(v)
 		}
+		for k8sConfigMapName := range k8sSecretsForUpdate {
+			configMap := &k8sCoreV1.ConfigMap{}
+			configMap.Name = k8sConfigMapName
+			configMap.Data = configMapData
+			configMap.Annotations = annotations
+			if err := d.updateConfigMapInK8s(numWorkerStr, namespace, vaultSecretPathFull, configMap, metadata, updateResults); err != nil {
+				updateResults.err = err
+				return *updateResults
+			}
+		}
+		return *updateResults
+	}
 
-		// Create/update secrets in k8s
-		annotations := make(map[string]string)
-		for k8sSecretName := range k8sSecretsForUpdate {
-			annotations[annotationName] = vaultSecretPathFull
-			secret := &k8sCoreV1.Secret{}
-			secret.Name = k8sSecretName
-			secret.Data = data
-			secret.Annotations = annotations
+	// Create/update secrets in k8s
+	for k8sSecretName := range k8sSecretsForUpdate {
+		secret := &k8sCoreV1.Secret{}
+		secret.Name = k8sSecretName
+		secret.Data = data
+		secret.Annotations = annotations
+		secret.Type = secretType
+
+		// Read k8s secret
+		start := time.Now()
+		existing, err := d.k8sClient.CoreV1().Secrets(namespace).Get(secret.Name, k8sMetaV1.GetOptions{})
+		observeAPIRequest(backendK8s, "get_secret", start, err)
+		if err != nil && !k8sApiErr.IsNotFound(err) {
+			glog.Errorln(numWorkerStr+"Error during get k8s secret:", err)
+			updateResults.skipped++
+			updateResults.addPlan(secret.Name, vaultSecretPathFull, actionSkip, "error during get k8s secret: "+err.Error())
+			d.recordNamespaceEvent(namespace, k8sCoreV1.EventTypeWarning, eventSyncFailed, "Failed to get secret '"+secret.Name+"' for Vault secret '"+vaultSecretPathFull+"': "+err.Error())
+			continue
+		}
 
-			// Read k8s secret
-			existing, err := d.k8sClient.CoreV1().Secrets(namespace).Get(secret.Name, k8sMetaV1.GetOptions{})
+		// Decide what should be done with k8s secret
+		var action, reason string
+		if err != nil {
+			action, reason = d.k8sObjectAction(vaultSecretPathFull, false, nil, false, "")
+		} else {
+			upToDate := reflect.DeepEqual(existing.Data, secret.Data) == true && (secretsTypes != "true" || secretTypeEqual(existing.Type, secret.Type)) && (metadata == nil || (existing.Annotations[d.versionAnnotationName()] == v && existing.Annotations[d.updatedTimeAnnotationName()] == metadata.updatedTime))
+			typeChange := ""
+			if secretsTypes == "true" && !secretTypeEqual(existing.Type, secret.Type) {
+				typeChange = "type was changed from '" + string(existing.Type) + "' to '" + string(secret.Type) + "'"
+			}
+			action, reason = d.k8sObjectAction(vaultSecretPathFull, true, existing.Annotations, upToDate, typeChange)
+		}
+		updateResults.addPlan(secret.Name, vaultSecretPathFull, action, reason)
+		if d.readOnly() {
+			continue
+		}
 
+		switch action {
+		case actionCreate:
 			// Create new secret
-			if k8sApiErr.IsNotFound(err) {
-				glog.V(2).Infoln(numWorkerStr + "Create k8s secret '" + secret.Name + "' from vault secret '" + vaultSecretPathFull + "'")
-				if _, err := d.k8sClient.CoreV1().Secrets(namespace).Create(secret); err != nil {
-					glog.Errorln(errors.Wrap(err, numWorkerStr+"Error during create k8s secret"))
-					updateResults.skipped++
-					continue
-				}
-				updateResults.created++
-				updateResults.synced++
-				continue
-			} else if err != nil {
-				glog.Errorln(numWorkerStr+"Error during get k8s secret:", err)
+			glog.V(2).Infoln(numWorkerStr + "Create k8s secret '" + secret.Name + "' from vault secret '" + vaultSecretPathFull + "'")
+			start := time.Now()
+			created, err := d.k8sClient.CoreV1().Secrets(namespace).Create(secret)
+			observeAPIRequest(backendK8s, "create_secret", start, err)
+			if err != nil {
+				glog.Errorln(errors.Wrap(err, numWorkerStr+"Error during create k8s secret"))
 				updateResults.skipped++
+				d.recordNamespaceEvent(namespace, k8sCoreV1.EventTypeWarning, eventSyncFailed, "Failed to create secret '"+secret.Name+"' from Vault secret '"+vaultSecretPathFull+"': "+err.Error())
 				continue
 			}
-
+			d.recordEvent(created, k8sCoreV1.EventTypeNormal, eventCreated, "Created from Vault secret '"+vaultSecretPathFull+"'")
+			updateResults.created++
+			updateResults.synced++
+		case actionNone:
 			// Skip update non-versioning secrets if it already up-to-date in k8s
-			if reflect.DeepEqual(existing.Data, secret.Data) == true {
-				glog.V(2).Infoln(numWorkerStr + "Ignoring update secret '" + secret.Name + "' in '" + namespace + "' namespace as it already up-to-date")
-				updateResults.synced++
-				continue
-			}
-
-			// Verify annotation
-			if _, ok := existing.Annotations[annotationName]; !ok {
-				glog.V(2).Infoln(numWorkerStr + "WARNING: Ignoring k8s secret '" + secret.Name + "' in '" + namespace + "' namespace as it not managed by '" + appName + "' application")
-				updateResults.skipped++
-				continue
+			glog.V(2).Infoln(numWorkerStr + "Ignoring update secret '" + secret.Name + "' in '" + namespace + "' namespace as it already up-to-date")
+			updateResults.synced++
+		case actionSkip:
+			// Secret isn't managed by application or belongs to another Vault secret
+			glog.V(2).Infoln(numWorkerStr + "WARNING: Ignoring k8s secret '" + secret.Name + "' in '" + namespace + "' namespace as it " + reason)
+			updateResults.skipped++
+			d.recordEvent(existing, k8sCoreV1.EventTypeWarning, d.skipEventReason(existing.Annotations), "Not synced from Vault secret '"+vaultSecretPathFull+"' as it "+reason)
+		case actionRecreate:
+			// Type of k8s secret is immutable, therefore recreate secret
+			glog.V(2).Infoln(numWorkerStr + "Recreate k8s secret '" + secret.Name + "' with '" + string(secret.Type) + "' type (was '" + string(existing.Type) + "') from vault secret '" + vaultSecretPathFull + "'")
+			start := time.Now()
+			err := d.k8sClient.CoreV1().Secrets(namespace).Delete(secret.Name, &k8sMetaV1.DeleteOptions{})
+			observeAPIRequest(backendK8s, "delete_secret", start, err)
+			if err != nil {
+				updateResults.err = errors.Wrap(err, "Error during delete k8s secret")
+				d.recordEvent(existing, k8sCoreV1.EventTypeWarning, eventSyncFailed, "Failed to delete for recreate from Vault secret '"+vaultSecretPathFull+"': "+err.Error())
+				return *updateResults
 			}
-			if existing.Annotations[annotationName] != vaultSecretPathFull {
-				glog.V(2).Infoln(numWorkerStr+"WARNING: Ignoring k8s secret '"+secret.Name+"' in '"+namespace+"' namespace as annotation for it has different path:", existing.Annotations[annotationName])
-				updateResults.skipped++
-				continue
+			start = time.Now()
+			created, err := d.k8sClient.CoreV1().Secrets(namespace).Create(secret)
+			observeAPIRequest(backendK8s, "create_secret", start, err)
+			if err != nil {
+				updateResults.err = errors.Wrap(err, "Error during recreate k8s secret")
+				d.recordNamespaceEvent(namespace, k8sCoreV1.EventTypeWarning, eventSyncFailed, "Failed to recreate secret '"+secret.Name+"' from Vault secret '"+vaultSecretPathFull+"': "+err.Error())
+				return *updateResults
 			}
-
+			d.recordEvent(created, k8sCoreV1.EventTypeNormal, eventRecreated, "Recreated from Vault secret '"+vaultSecretPathFull+"' as "+reason)
+			updateResults.updated++
+			updateResults.synced++
+			d.rolloutWorkloadsOnChange(numWorkerStr, namespace, existing, secret, k8sSecretsForUpdate[k8sSecretName], updateResults)
+		case actionUpdate:
 			// Update secret
 			glog.V(2).Infoln(numWorkerStr + "Update k8s secret '" + secret.Name + "' from vault secret '" + vaultSecretPathFull + "'")
-			_, _ = d.k8sClient.CoreV1().Secrets(namespace).Update(secret)
-			if _, err = d.k8sClient.CoreV1().Secrets(namespace).Update(secret); err != nil {
+			start := time.Now()
+			updated, err := d.k8sClient.CoreV1().Secrets(namespace).Update(secret)
+			observeAPIRequest(backendK8s, "update_secret", start, err)
+			if err != nil {
 				updateResults.err = errors.Wrap(err, "Error during update k8s secret")
-				usrc <- *updateResults
-				cancel()
-				return
+				d.recordEvent(existing, k8sCoreV1.EventTypeWarning, eventSyncFailed, "Failed to update from Vault secret '"+vaultSecretPathFull+"': "+err.Error())
+				return *updateResults
 			}
+			d.recordEvent(updated, k8sCoreV1.EventTypeNormal, eventUpdated, "Updated from Vault secret '"+vaultSecretPathFull+"'")
 			updateResults.updated++
 			updateResults.synced++
+			d.rolloutWorkloadsOnChange(numWorkerStr, namespace, existing, secret, k8sSecretsForUpdate[k8sSecretName], updateResults)
 		}
-		usrc <- *updateResults
 	}
+	return *updateResults
 }
 
 func main() {
@@ -698,7 +1490,11 @@ func main() {
 	flag.StringVar(&approleRoleID, "approle_role_id", getEnvWithDefaultString("APPROLE_ROLE_ID", ""), "Vault AppRole Role ID")
 	flag.StringVar(&approleSecretIDWrappedToken, "approle_secret_id_wrapped_token", getEnvWithDefaultString("APPROLE_SECRET_ID_WRAPPED_TOKEN", ""), "Vault AppRole wrapped token for getting Secret ID")
 	flag.StringVar(&approleSecretIDWrappedTokenFile, "approle_secret_id_wrapped_token_file", getEnvWithDefaultString("APPROLE_SECRET_ID_WRAPPED_TOKEN_FILE", ""), "File with Vault AppRole wrapped token")
+	flag.StringVar(&kubernetesRole, "kubernetes_role", getEnvWithDefaultString("KUBERNETES_ROLE", ""), "Vault role for 'kubernetes' auth method")
+	flag.StringVar(&kubernetesAuthPath, "kubernetes_auth_path", getEnvWithDefaultString("KUBERNETES_AUTH_PATH", "kubernetes"), "Vault mount path of 'kubernetes' auth method")
+	flag.StringVar(&kubernetesTokenFile, "kubernetes_token_file", getEnvWithDefaultString("KUBERNETES_TOKEN_FILE", "/var/run/secrets/kubernetes.io/serviceaccount/token"), "File with ServiceAccount JWT for 'kubernetes' auth method")
 	flag.IntVar(&tokenRotationInterval, "token_rotation_interval", getEnvWithDefaultInt("TOKEN_ROTATION_INTERVAL", -1), "Vault Token rotation interval")
+	flag.StringVar(&tokenRenewal, "token_renewal", getEnvWithDefaultString("TOKEN_RENEWAL", "true"), "Renew renewable Vault Token instead of rotation, Token is rotated only when it reaches max TTL or renewal fails")
 	flag.IntVar(&approleSecretIDRotationInterval, "approle_secretid_rotation_interval", getEnvWithDefaultInt("APPROLE_SECRETID_ROTATION_INTERVAL", -1), "Vault AppRole Secret ID rotation interval")
 	flag.IntVar(&numWorkers, "num_workers", getEnvWithDefaultInt("NUM_WORKERS", 1), "Number of workers for read/create/update secrets")
 	flag.IntVar(&syncInterval, "sync_interval", getEnvWithDefaultInt("SYNC_INTERVAL", 300), "Interval of sync secrets from Vault to k8s")
@@ -706,9 +1502,44 @@ func main() {
 	flag.StringVar(&vaultSecretsPath, "secrets_path_vault", getEnvWithDefaultString("SECRETS_PATH_VAULT", ""), "Paths to secrets in Vault")
 	flag.StringVar(&nonVersioningNamespaces, "non_versioning_namespaces", getEnvWithDefaultString("NON_VERSIONING_NAMESPACES", ""), "Non-versioning namespaces")
 	flag.StringVar(&annotationName, "annotation_name", getEnvWithDefaultString("ANNOTATION_NAME", "vault-to-k8s/secret"), "Annotation name for k8s Secret object")
+	flag.StringVar(&pruneSecrets, "prune_secrets", getEnvWithDefaultString("PRUNE_SECRETS", "false"), "Delete managed k8s secrets which source was deleted from Vault")
+	flag.IntVar(&pruneGracePeriod, "prune_grace_period", getEnvWithDefaultInt("PRUNE_GRACE_PERIOD", 3600), "How many seconds to wait before prune k8s secret which source was deleted from Vault")
+	flag.IntVar(&pruneMaxPerNamespace, "prune_max_per_namespace", getEnvWithDefaultInt("PRUNE_MAX_PER_NAMESPACE", 10), "Maximum number of deleted Vault secrets which k8s secrets can be pruned in namespace during sync cycle")
+	flag.IntVar(&versionsRetention, "secrets_versions_retention", getEnvWithDefaultInt("SECRETS_VERSIONS_RETENTION", 0), "Number of versions to keep for each versioning k8s secret")
+	flag.StringVar(&versionsRetentionNamespaces, "secrets_versions_retention_namespaces", getEnvWithDefaultString("SECRETS_VERSIONS_RETENTION_NAMESPACES", ""), "Number of versions to keep for each versioning k8s secret per namespace")
+	flag.StringVar(&versionsGC, "secrets_versions_gc", getEnvWithDefaultString("SECRETS_VERSIONS_GC", "false"), "Delete superseded versions of k8s secrets only when they aren't referenced by workloads")
+	flag.IntVar(&versionsGCPeriod, "secrets_versions_gc_period", getEnvWithDefaultInt("SECRETS_VERSIONS_GC_PERIOD", 3600), "How many seconds superseded version of k8s secret should be unreferenced before delete")
+	flag.StringVar(&metadataChangeDetection, "metadata_change_detection", getEnvWithDefaultString("METADATA_CHANGE_DETECTION", "false"), "Read data of Vault secret only if its metadata was changed")
+	flag.StringVar(&configMaps, "configmaps", getEnvWithDefaultString("CONFIGMAPS", "false"), "Sync marked Vault secrets to k8s ConfigMaps")
+	flag.StringVar(&configMapsPrefix, "configmaps_prefix", getEnvWithDefaultString("CONFIGMAPS_PREFIX", ""), "Prefix of Vault secret name which should be synced to k8s ConfigMap")
+	flag.StringVar(&secretsTypes, "secrets_types", getEnvWithDefaultString("SECRETS_TYPES", "false"), "Set type of k8s secrets from Vault secret custom metadata or names of keys")
+	flag.IntVar(&secretsMaxDepth, "secrets_max_depth", getEnvWithDefaultInt("SECRETS_MAX_DEPTH", 1), "How many levels of Vault subdirectories under namespace should be synced")
+	flag.StringVar(&secretsPathJoiner, "secrets_path_joiner", getEnvWithDefaultString("SECRETS_PATH_JOINER", "-"), "String which replaces '/' in path of Vault secret from subdirectory for k8s secret name")
+	flag.StringVar(&nonStringValues, "non_string_values", getEnvWithDefaultString("NON_STRING_VALUES", "skip"), "Strategy for non-string values of Vault secret: 'skip', 'json' or 'flatten'")
+	flag.StringVar(&flattenSeparator, "flatten_separator", getEnvWithDefaultString("FLATTEN_SEPARATOR", "."), "Separator of keys for 'flatten' strategy of non-string values")
+	flag.StringVar(&leaderElection, "leader_election", getEnvWithDefaultString("LEADER_ELECTION", "false"), "Sync secrets only on the leader of application replicas")
+	flag.StringVar(&leaderElectionLeaseName, "leader_election_lease_name", getEnvWithDefaultString("LEADER_ELECTION_LEASE_NAME", ""), "Name of Lease object for leader election (default is APP_NAME)")
+	flag.IntVar(&leaderElectionLeaseDuration, "leader_election_lease_duration", getEnvWithDefaultInt("LEADER_ELECTION_LEASE_DURATION", 15), "How many seconds standby replicas wait before take over leadership")
+	flag.IntVar(&leaderElectionRenewDeadline, "leader_election_renew_deadline", getEnvWithDefaultInt("LEADER_ELECTION_RENEW_DEADLINE", 10), "How many seconds leader retries renew of leadership before give it up")
+	flag.IntVar(&leaderElectionRetryPeriod, "leader_election_retry_period", getEnvWithDefaultInt("LEADER_ELECTION_RETRY_PERIOD", 2), "How many seconds replicas wait between tries of leader election actions")
+	flag.StringVar(&namespacesMapping, "namespaces_mapping", getEnvWithDefaultString("NAMESPACES_MAPPING", ""), "Rules of mapping Vault directories to k8s namespaces, format: '<vault dir>=<namespace>[|<namespace>]' separated by comma (except commas inside braces, brackets and parentheses of regex), regex for Vault directory is defined with '~' prefix")
+	flag.StringVar(&namespacesLabelSelector, "namespaces_label_selector", getEnvWithDefaultString("NAMESPACES_LABEL_SELECTOR", ""), "Label selector of k8s namespaces which are eligible for sync")
+	flag.StringVar(&namespacesDenyList, "namespaces_deny_list", getEnvWithDefaultString("NAMESPACES_DENY_LIST", "kube-system,kube-public,kube-node-lease"), "K8s namespaces which are never synced, separated by comma")
+	flag.StringVar(&namespacesOptInAnnotation, "namespaces_opt_in_annotation", getEnvWithDefaultString("NAMESPACES_OPT_IN_ANNOTATION", ""), "Only k8s namespaces with this annotation set to 'true' are eligible for sync")
+	flag.StringVar(&events, "events", getEnvWithDefaultString("EVENTS", "false"), "Record k8s Events about sync results of secrets and configmaps")
+	flag.StringVar(&vaultSecretsCRD, "vault_secrets_crd", getEnvWithDefaultString("VAULT_SECRETS_CRD", "false"), "Sync secrets declared by VaultSecret custom resources")
+	flag.IntVar(&vaultSecretsPollInterval, "vault_secrets_poll_interval", getEnvWithDefaultInt("VAULT_SECRETS_POLL_INTERVAL", 30), "How many seconds to wait between checks of VaultSecret resources")
+	flag.StringVar(&rollout, "rollout", getEnvWithDefaultString("ROLLOUT", "false"), "Roll out opted-in workloads which use non-versioning secret when its data is changed")
+	flag.StringVar(&configFile, "config_file", getEnvWithDefaultString("CONFIG_FILE", ""), "YAML file with sources of secrets for sync")
+	flag.BoolVar(&once, "once", getEnvWithDefaultBool("ONCE", false), "Run one sync of all namespaces and exit")
+	flag.StringVar(&pushgatewayURL, "pushgateway_url", getEnvWithDefaultString("PUSHGATEWAY_URL", ""), "URL of Pushgateway for push metrics before exit in one-shot mode")
+	flag.StringVar(&dryRun, "dry_run", getEnvWithDefaultString("DRY_RUN", "false"), "Log planned changes without create/update/delete of k8s objects")
+	flag.IntVar(&shutdownTimeout, "shutdown_timeout", getEnvWithDefaultInt("SHUTDOWN_TIMEOUT", 30), "How many seconds to wait for in-flight sync before exit on SIGTERM")
+	flag.IntVar(&healthSyncIntervals, "health_sync_intervals", getEnvWithDefaultInt("HEALTH_SYNC_INTERVALS", 3), "How many sync intervals without completed sync cycle make application unhealthy")
 	flag.StringVar(&prometheusMetrics, "prometheus_metrics", getEnvWithDefaultString("PROMETHEUS_METRICS", "true"), "Prometheus metrics")
 	flag.StringVar(&prometheusListenAddress, "prometheus_listen_address", getEnvWithDefaultString("PROMETHEUS_LISTEN_ADDRESS", ":9703"), "Address on which expose metrics and web interface")
 	flag.StringVar(&prometheusMetricsPath, "prometheus_metrics_path", getEnvWithDefaultString("PROMETHEUS_METRICS_PATH", "/metrics"), "Path under which to expose metrics")
+	flag.StringVar(&perSecretMetrics, "per_secret_metrics", getEnvWithDefaultString("PER_SECRET_METRICS", "false"), "Export synced version and age of each Vault secret (high cardinality)")
 	flag.Parse()
 
 	// Debug mode
@@ -727,35 +1558,151 @@ func main() {
 		glog.Fatal(err)
 	}
 
+	// Subcommands
+	switch flag.Arg(0) {
+	case "":
+	case "diff":
+		code := d.runDiff(flag.Args()[1:], os.Stdout)
+		glog.Flush()
+		os.Exit(code)
+	default:
+		glog.Fatal("Unknown subcommand '" + flag.Arg(0) + "'")
+	}
+
+	// Stop application on SIGTERM/SIGINT
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	// One-shot sync doesn't serve metrics, they can be pushed to Pushgateway
+	if once {
+		registerMetrics()
+		lastSyncStatus := d.run(ctx)
+		if pushgatewayURL != "" {
+			if err := pushMetrics(); err != nil {
+				glog.Errorln(err)
+			}
+		}
+		if !lastSyncStatus {
+			glog.Errorln("Sync was unsuccessful")
+			glog.Flush()
+			os.Exit(1)
+		}
+		glog.Infoln("Sync was successful")
+		glog.Flush()
+		return
+	}
+
+	// Prometheus metrics
+	metricsStopped := make(chan struct{})
+	if prometheusMetrics == "true" {
+		go func() {
+			prometheusMetricsFunc(ctx)
+			close(metricsStopped)
+		}()
+	} else {
+		close(metricsStopped)
+	}
+
+	// Standby replicas only serve metrics until they become the leader
+	var lastSyncStatus bool
+	if leaderElection == "true" {
+		lastSyncStatus = d.runWithLeaderElection(ctx, leaderElectionIdentity(), d.run)
+	} else {
+		lastSyncStatus = d.run(ctx)
+	}
+
+	cancel()
+	<-metricsStopped
+	glog.Infoln("Stopped '" + appName + "'")
+	if !lastSyncStatus {
+		glog.Errorln("Last sync was unsuccessful")
+		glog.Flush()
+		os.Exit(1)
+	}
+	glog.Flush()
+}
+
+// Authenticate in Vault and run sync Vault secrets to k8s until context is canceled (or once), returns status of the last sync
+func (d *vtkData) run(ctx context.Context) bool {
+	health.setActive()
+
+	// Rotation goroutines are stopped together with sync
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
 	// Authentication
-	if authMethod == "approle" {
+	if dryRun == "true" || once {
+		// Dry run and one sync don't change credentials of running application and its system secret
+		if err := d.authenticateReadOnly(); err != nil {
+			glog.Fatal(err)
+		}
+		// Token is revoked after the end of all goroutines
+		defer func() {
+			wg.Wait()
+			d.revokeReadOnlyToken()
+		}()
+	} else if authMethod == "approle" {
 		// Authenticate in Vault
 		if err := d.approleAuthenticate(); err != nil {
 			glog.Fatal(err)
 		}
 		// AppRole Secret ID rotation
 		if approleSecretIDRotationInterval != 0 {
-			go d.approleSecretIDRotation()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				d.approleSecretIDRotation(ctx)
+			}()
+		}
+	} else if authMethod == "kubernetes" {
+		// Authenticate in Vault
+		if err := d.kubernetesAuthenticate(); err != nil {
+			glog.Fatal(err)
 		}
 	}
 
 	// Token rotation
-	if tokenRotationInterval != 0 {
-		go d.tokenRotation()
+	if authMethod != "token" && tokenRotationInterval != 0 && !once {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.tokenRotation(ctx)
+		}()
 	}
 
-	// Verify if mount exists in Vault and has correct engine version
-	if err := d.verifyVaultMount(); err != nil {
-		glog.Fatal(err)
+	// Verify if mounts exist in Vault and have correct engine version
+	for _, source := range d.syncSources() {
+		if err := source.verifyVaultMount(); err != nil {
+			glog.Fatal(err)
+		}
+		if source.sourceName() != "" {
+			glog.Infoln("Source '" + source.sourceName() + "' with path '" + source.vaultSecretsPath() + "', cluster name '" + source.k8sClusterName() + "' and '" + strconv.Itoa(source.numWorkers()) + "' worker(s)")
+		}
+	}
+	health.setAuthenticated()
+
+	// Run one sync Vault secrets to k8s
+	if once {
+		glog.Infoln("Started '" + appName + "' for one sync with '" + strconv.Itoa(d.numWorkers()) + "' worker(s)")
+		syncStatus := d.syncCycle(ctx)
+		if vaultSecretsCRD == "true" && !d.syncVaultSecretsCycle(ctx, time.Now()) {
+			syncStatus = false
+		}
+		return syncStatus
 	}
 
-	// Prometheus metrics
-	if prometheusMetrics == "true" {
-		go prometheusMetricsFunc()
+	// VaultSecret custom resources are synced alongside Vault directories
+	if vaultSecretsCRD == "true" {
+		glog.Infoln("Started sync of VaultSecret resources with poll interval '" + strconv.Itoa(vaultSecretsPollInterval) + "' seconds")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.syncVaultSecrets(ctx)
+		}()
 	}
 
-	glog.Infoln("Started '" + appName + "' with sync interval '" + strconv.Itoa(syncInterval) + "' seconds and '" + strconv.Itoa(numWorkers) + "' worker(s)")
+	glog.Infoln("Started '" + appName + "' with sync interval '" + strconv.Itoa(syncInterval) + "' seconds and '" + strconv.Itoa(d.numWorkers()) + "' worker(s)")
 
 	// Run sync Vault secrets to k8s
-	d.syncVaultToK8s()
+	return d.syncVaultToK8s(ctx)
 }
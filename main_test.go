@@ -2,10 +2,19 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
 	"strings"
 	"sync"
 	"testing"
+	"time"
+
+	k8sCoreV1 "k8s.io/api/core/v1"
+	k8sMetaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8sTesting "k8s.io/client-go/testing"
 )
 
 // Define application init params
@@ -18,6 +27,26 @@ func defineAppInitParams() {
 	k8sClusterName = "k8s-cluster"
 	vaultSecretsPath = "testMount/k8s/dev"
 	annotationName = "vault-to-k8s/secret"
+	secretsMaxDepth = 1
+	secretsPathJoiner = "-"
+	secretsTypes = "false"
+	configMaps = "false"
+	configMapsPrefix = ""
+	nonStringValues = "skip"
+	flattenSeparator = "."
+	leaderElection = "false"
+	leaderElectionLeaseName = ""
+	leaderElectionLeaseDuration = 15
+	leaderElectionRenewDeadline = 10
+	leaderElectionRetryPeriod = 2
+	shutdownTimeout = 30
+	healthSyncIntervals = 3
+	dryRun = "false"
+	once = false
+	pushgatewayURL = ""
+	rollout = "false"
+	perSecretMetrics = "false"
+	tokenRenewal = "true"
 }
 
 // Run before start testing
@@ -35,6 +64,50 @@ func checkItemInArray(arr []string, str string) bool {
 	return false
 }
 
+// Run create/update secrets in k8s for filtered secrets
+func (d *vtkData) testRunUpdateSecretsInK8s(t *testing.T, filteredSecrets map[string]int, namespace string) {
+	t.Helper()
+
+	// Get list of k8s secrets
+	k8sSecrets, err := d.k8sSecretsList(namespace)
+	if err != nil {
+		t.Log(err)
+		t.Fatal("Error should not be raised")
+	}
+	k8sConfigMaps, err := d.k8sConfigMapsList(namespace)
+	if err != nil {
+		t.Log(err)
+		t.Fatal("Error should not be raised")
+	}
+
+	usjc := make(chan secretForUpdate, numWorkers)
+	usrc := make(chan updateSecretResults, numWorkers)
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wg.Add(numWorkers)
+	for w := 1; w <= numWorkers; w++ {
+		go d.updateSecretsInK8s(ctx, cancel, w, &wg, usjc, usrc, namespace, namespace, "."+k8sClusterName, k8sSecrets, k8sConfigMaps)
+	}
+	go func() {
+		for filteredSecret, versioning := range filteredSecrets {
+			usjc <- secretForUpdate{name: filteredSecret, versioning: versioning}
+		}
+		close(usjc)
+	}()
+
+	for i := 1; i <= len(filteredSecrets); i++ {
+		usrcResult := <-usrc
+		if usrcResult.err != nil {
+			wg.Wait()
+			t.Log(usrcResult.err)
+			t.Fatal("Error should not be raised")
+		}
+	}
+	close(usrc)
+}
+
 // Test get 'string' environment variable with defined ENV variable
 func TestGetEnvWithDefaultStringDefinedEnv(t *testing.T) {
 	os.Setenv("PARAM1", "test")
@@ -69,6 +142,23 @@ func TestGetEnvWithDefaultIntWithoutDefinedEnv(t *testing.T) {
 	}
 }
 
+// Test get 'bool' environment variable with defined ENV variable
+func TestGetEnvWithDefaultBoolDefinedEnv(t *testing.T) {
+	os.Setenv("PARAM5", "true")
+	result := getEnvWithDefaultBool("PARAM5", false)
+	if result != true {
+		t.Fatalf("Incorrect value '%t', expected 'true'", result)
+	}
+}
+
+// Test get 'bool' environment variable without defined ENV variable, return default value
+func TestGetEnvWithDefaultBoolWithoutDefinedEnv(t *testing.T) {
+	result := getEnvWithDefaultBool("PARAM6", true)
+	if result != true {
+		t.Fatalf("Incorrect value '%t', expected 'true'", result)
+	}
+}
+
 // Test get pod namespace variable with defined ENV variable
 func TestGetEnvPodNamespaceDefinedEnv(t *testing.T) {
 	os.Setenv("POD_NAMESPACE", "my-k8s-ns")
@@ -89,6 +179,55 @@ func TestVerifyConfig(t *testing.T) {
 	}
 }
 
+// Test verify config parameters for "kubernetes" auth method without role
+func TestVerifyConfigKubernetesNoRole(t *testing.T) {
+	authMethod = "kubernetes"
+	kubernetesRole = ""
+	err := verifyConfig()
+	// Re-init default app params
+	defineAppInitParams()
+	if err == nil {
+		t.Fatal("Expected error, but it wasn't returned")
+	}
+
+	if !strings.Contains(err.Error(), "KUBERNETES_ROLE should be defined") {
+		t.Log(err)
+		t.Fatal("Incorrect error response")
+	}
+}
+
+// Test verify configuration: incorrect strategy of non-string values
+func TestVerifyConfigIncorrectNonStringValues(t *testing.T) {
+	nonStringValues = "base64"
+	err := verifyConfig()
+	// Re-init default app params
+	defineAppInitParams()
+	if err == nil {
+		t.Fatal("Expected error, but it wasn't returned")
+	}
+
+	if !strings.Contains(err.Error(), "Incorrect value for NON_STRING_VALUES") {
+		t.Log(err)
+		t.Fatal("Incorrect error response")
+	}
+}
+
+// Test verify configuration: Pushgateway without one sync
+func TestVerifyConfigPushgatewayWithoutOnce(t *testing.T) {
+	pushgatewayURL = "http://pushgateway:9091"
+	err := verifyConfig()
+	// Re-init default app params
+	defineAppInitParams()
+	if err == nil {
+		t.Fatal("Expected error, but it wasn't returned")
+	}
+
+	if !strings.Contains(err.Error(), "PUSHGATEWAY_URL can be used only with ONCE") {
+		t.Log(err)
+		t.Fatal("Incorrect error response")
+	}
+}
+
 // Test verify if mount exists in Vault and has correct engine version: wrong mount path
 func TestVerifyVaultMountWrongMountPath(t *testing.T) {
 	d := &vtkData{}
@@ -129,23 +268,23 @@ func TestVerifyVaultMountWrongEngineType(t *testing.T) {
 	}
 }
 
-// Test verify if mount exists in Vault and has correct engine version: wrong engine version
-func TestVerifyVaultMountWrongEngineVersion(t *testing.T) {
+// Test verify if mount exists in Vault and has correct engine version: KV version 1
+func TestVerifyVaultMountKVVersion1(t *testing.T) {
 	d := &vtkData{}
 	tvsd := d.testVaultServer(t)
 	defer tvsd.server.Close()
-	vaultSecretsPath = "secret/k8s/dev"
+	vaultSecretsPath = tvsKV1MountPath + "/k8s/dev"
 
 	err := d.verifyVaultMount()
 	// Re-init default app params
 	defineAppInitParams()
-	if err == nil {
-		t.Fatal("Expected error, but it wasn't returned")
+	if err != nil {
+		t.Log(err)
+		t.Fatal("Error should not be raised")
 	}
 
-	if !strings.Contains(err.Error(), "Vault mount version is not '2'") {
-		t.Log(err)
-		t.Fatal("Incorrect error response")
+	if d.kvVersion != 1 {
+		t.Fatalf("Incorrect KV version '%d', expected '1'", d.kvVersion)
 	}
 }
 
@@ -159,6 +298,10 @@ func TestVerifyVaultMount(t *testing.T) {
 		t.Log(err)
 		t.Fatal("Error should not be raised")
 	}
+
+	if d.kvVersion != 2 {
+		t.Fatalf("Incorrect KV version '%d', expected '2'", d.kvVersion)
+	}
 }
 
 // Test list namespaces from Vault with wrong path
@@ -276,6 +419,49 @@ func TestSecretsRead(t *testing.T) {
 	}
 }
 
+// Test read secret metadata from Vault
+func TestSecretsReadMetadata(t *testing.T) {
+	d := &vtkData{}
+	tvsd := d.testVaultServer(t)
+	defer tvsd.server.Close()
+
+	metadata, err := d.secretsReadMetadata(vaultSecretsPath + "/k8s-ns1/secret1")
+	if err != nil {
+		t.Log(err)
+		t.Fatal("Error should not be raised")
+	}
+	if metadata.version != "2" {
+		t.Fatalf("Incorrect value '%s', expected '2'", metadata.version)
+	}
+	if metadata.updatedTime == "" {
+		t.Fatal("Update time of secret should be defined")
+	}
+	if metadata.deleted {
+		t.Fatal("Secret shouldn't be marked as deleted")
+	}
+
+	// Deleted secret
+	d.testVaultServerDeleteSecret(t, "secret1", "k8s-ns1")
+	metadata, err = d.secretsReadMetadata(vaultSecretsPath + "/k8s-ns1/secret1")
+	if err != nil {
+		t.Log(err)
+		t.Fatal("Error should not be raised")
+	}
+	if !metadata.deleted {
+		t.Fatal("Secret should be marked as deleted")
+	}
+
+	// Secret which doesn't exist
+	metadata, err = d.secretsReadMetadata(vaultSecretsPath + "/k8s-ns1/secret1-wrong")
+	if err != nil {
+		t.Log(err)
+		t.Fatal("Error should not be raised")
+	}
+	if metadata != nil {
+		t.Fatalf("Incorrect value '%v', expected 'nil'", metadata)
+	}
+}
+
 // Test generate secrets list for versioning namespace
 func TestFilterSecretsVersioningNS(t *testing.T) {
 	d := &vtkData{}
@@ -396,13 +582,13 @@ func TestUpdateSecretsInK8sVersioningNS(t *testing.T) {
 	// WaitGroup is used to wait for the program to finish goroutines
 	var wg sync.WaitGroup
 	// Use context for cancelation signal in goroutines if errors occurs
-	_, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel() // Make sure it's called to release resources even if no errors
 
 	// Create goroutines
 	wg.Add(numWorkers)
 	for w := 1; w <= numWorkers; w++ {
-		go d.updateSecretsInK8s(cancel, w, &wg, usjc, usrc, "k8s-ns1", "."+k8sClusterName, k8sSecrets)
+		go d.updateSecretsInK8s(ctx, cancel, w, &wg, usjc, usrc, "k8s-ns1", "k8s-ns1", "."+k8sClusterName, k8sSecrets, []string{})
 	}
 
 	// Send secrets to goroutines
@@ -570,13 +756,13 @@ func TestUpdateSecretsInK8sWithBadSecretName(t *testing.T) {
 	// WaitGroup is used to wait for the program to finish goroutines
 	var wg sync.WaitGroup
 	// Use context for cancelation signal in goroutines if errors occurs
-	_, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel() // Make sure it's called to release resources even if no errors
 
 	// Create goroutines
 	wg.Add(numWorkers)
 	for w := 1; w <= numWorkers; w++ {
-		go d.updateSecretsInK8s(cancel, w, &wg, usjc, usrc, "k8s-ns1", "."+k8sClusterName, k8sSecrets)
+		go d.updateSecretsInK8s(ctx, cancel, w, &wg, usjc, usrc, "k8s-ns1", "k8s-ns1", "."+k8sClusterName, k8sSecrets, []string{})
 	}
 
 	// Send secrets to goroutines
@@ -652,13 +838,13 @@ func TestUpdateSecretsInK8sWithIncorrectData(t *testing.T) {
 	// WaitGroup is used to wait for the program to finish goroutines
 	var wg sync.WaitGroup
 	// Use context for cancelation signal in goroutines if errors occurs
-	_, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel() // Make sure it's called to release resources even if no errors
 
 	// Create goroutines
 	wg.Add(numWorkers)
 	for w := 1; w <= numWorkers; w++ {
-		go d.updateSecretsInK8s(cancel, w, &wg, usjc, usrc, "k8s-ns1", "."+k8sClusterName, k8sSecrets)
+		go d.updateSecretsInK8s(ctx, cancel, w, &wg, usjc, usrc, "k8s-ns1", "k8s-ns1", "."+k8sClusterName, k8sSecrets, []string{})
 	}
 
 	// Send secrets to goroutines
@@ -720,13 +906,13 @@ func TestUpdateSecretsInK8sNonVersioningNS(t *testing.T) {
 	// WaitGroup is used to wait for the program to finish goroutines
 	var wg sync.WaitGroup
 	// Use context for cancelation signal in goroutines if errors occurs
-	_, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel() // Make sure it's called to release resources even if no errors
 
 	// Create goroutines
 	wg.Add(numWorkers)
 	for w := 1; w <= numWorkers; w++ {
-		go d.updateSecretsInK8s(cancel, w, &wg, usjc, usrc, "k8s-ns1", "."+k8sClusterName, k8sSecrets)
+		go d.updateSecretsInK8s(ctx, cancel, w, &wg, usjc, usrc, "k8s-ns1", "k8s-ns1", "."+k8sClusterName, k8sSecrets, []string{})
 	}
 
 	// Send secrets to goroutines
@@ -804,3 +990,476 @@ func TestUpdateSecretsInK8sNonVersioningNS(t *testing.T) {
 		t.Fatalf("Incorrect value for secret key '%s': '%s'. Expected '%s'", secret2TestKeyNonV, secret2TestKeyValueNonV, secret2TestKeyValueExpectedNonV)
 	}
 }
+
+// Test create/update secrets in k8s with metadata change detection
+func TestUpdateSecretsInK8sMetadataChangeDetection(t *testing.T) {
+	d := &vtkData{}
+	tvsd := d.testVaultServer(t)
+	defer tvsd.server.Close()
+	_ = d.testK8sServer(t)
+	metadataChangeDetection = "true"
+	defer func() { metadataChangeDetection = "false" }()
+
+	secret2Path := vaultSecretsPath + "/k8s-ns1/" + tvsd.secretsList[1]
+	metadata, err := d.secretsReadMetadata(secret2Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k8sSecret2Name := strings.TrimSuffix(tvsd.secretsList[1], "."+k8sClusterName)
+	// Secret2 was already synced from current version
+	d.testK8sServerCreateSecret(t, tvsd.secretsList[1]+"-v1", "k8s-ns1", annotationName, secret2Path)
+	d.testK8sServerCreateSecret(t, k8sSecret2Name, "k8s-ns1", annotationName, secret2Path)
+	secret2NonV, _ := d.testK8sServerReadTestSecret(t, k8sSecret2Name, "k8s-ns1")
+	secret2NonV.Annotations[d.versionAnnotationName()] = metadata.version
+	secret2NonV.Annotations[d.updatedTimeAnnotationName()] = metadata.updatedTime
+	if _, err := d.k8sClient.CoreV1().Secrets("k8s-ns1").Update(secret2NonV); err != nil {
+		t.Fatal(err)
+	}
+
+	numWorkers = 2
+	d.testRunUpdateSecretsInK8s(t, map[string]int{tvsd.secretsList[1]: 0, "secret1": 1}, "k8s-ns1")
+
+	// Data of secret2 shouldn't be read and updated as its version is already in k8s
+	secret2NonV, err = d.testK8sServerReadTestSecret(t, k8sSecret2Name, "k8s-ns1")
+	if err != nil {
+		t.Log(err)
+		t.Fatal("Error should not be raised")
+	}
+	if _, ok := secret2NonV.Data["testKey-"+tvsd.secretsList[1]]; ok {
+		t.Fatalf("Secret '%s' shouldn't be updated", k8sSecret2Name)
+	}
+
+	// Secret1 should be created with version annotations
+	secret1, err := d.testK8sServerReadTestSecret(t, "secret1-v2", "k8s-ns1")
+	if err != nil {
+		t.Log(err)
+		t.Fatal("Error should not be raised")
+	}
+	if secret1.Annotations[d.versionAnnotationName()] != "2" {
+		t.Fatalf("Incorrect value for secret annotation '%s': '%s'. Expected '2'", d.versionAnnotationName(), secret1.Annotations[d.versionAnnotationName()])
+	}
+	if secret1.Annotations[d.updatedTimeAnnotationName()] == "" {
+		t.Fatalf("Secret annotation '%s' should be defined", d.updatedTimeAnnotationName())
+	}
+}
+
+// Test check of synced version of non-versioning secret
+func TestK8sSecretsUpToDate(t *testing.T) {
+	d := &vtkData{}
+	tvsd := d.testVaultServer(t)
+	defer tvsd.server.Close()
+	_ = d.testK8sServer(t)
+	defer defineAppInitParams()
+
+	secretPath := vaultSecretsPath + "/k8s-ns1/app." + k8sClusterName
+	metadata := &vaultSecretMetadata{version: "1", updatedTime: "2020-01-01T00:00:00Z"}
+	secretForUpdate := secretForUpdate{name: "app." + k8sClusterName, versioning: 0}
+	k8sSecrets := []string{"app", "app." + k8sClusterName + "-v1"}
+
+	// Secret without annotation isn't up-to-date
+	d.testK8sServerCreateSecret(t, "app", "k8s-ns1", "other-app/secret", secretPath)
+	upToDate, err := d.k8sSecretsUpToDate("k8s-ns1", secretPath, "."+k8sClusterName, secretForUpdate, metadata, k8sSecrets, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upToDate {
+		t.Fatal("Secret which isn't managed by application shouldn't be up-to-date")
+	}
+
+	// Errors except not found are returned
+	getSecretsReactor := func(action k8sTesting.Action) (handled bool, ret runtime.Object, err error) {
+		return true, nil, errors.New("get isn't allowed")
+	}
+	d.k8sClient.(*fake.Clientset).PrependReactor("get", "secrets", getSecretsReactor)
+	if _, err := d.k8sSecretsUpToDate("k8s-ns1", secretPath, "."+k8sClusterName, secretForUpdate, metadata, k8sSecrets, false); err == nil {
+		t.Fatal("Error should be raised")
+	}
+}
+
+// Test list, read and create/update secrets in k8s from KV version 1 mount
+func TestUpdateSecretsInK8sKVVersion1(t *testing.T) {
+	d := &vtkData{}
+	tvsd := d.testVaultServer(t)
+	defer tvsd.server.Close()
+	_ = d.testK8sServer(t)
+	vaultSecretsPath = tvsKV1MountPath + "/k8s/dev"
+	defer defineAppInitParams()
+	d.kvVersion = 1
+
+	d.testVaultServerCreateSecretsKV1(t, tvsd.secretsList, "k8s-ns1")
+
+	listSecrets, err := d.secretsList("k8s-ns1")
+	if err != nil {
+		t.Log(err)
+		t.Fatal("Error should not be raised")
+	}
+	if len(listSecrets) != len(tvsd.secretsList) {
+		t.Fatalf("Incorrect number of secrets '%d', expected '%d'", len(listSecrets), len(tvsd.secretsList))
+	}
+
+	secretData, secretVersion, err := d.secretsRead(vaultSecretsPath + "/k8s-ns1/secret1")
+	if err != nil {
+		t.Log(err)
+		t.Fatal("Error should not be raised")
+	}
+	if secretData["testKey-secret1"] != "testValue-secret1" {
+		t.Fatalf("Incorrect value '%s', expected 'testValue-secret1'", secretData["testKey-secret1"])
+	}
+	if secretVersion != "" {
+		t.Fatalf("Incorrect value '%s', expected ''", secretVersion)
+	}
+
+	numWorkers = 2
+	d.testRunUpdateSecretsInK8s(t, d.filterSecrets(listSecrets, "."+k8sClusterName, "k8s-ns-nonver"), "k8s-ns1")
+
+	// Secrets should be created without version in name
+	for _, secretName := range []string{"secret1", "secret2"} {
+		secret, err := d.testK8sServerReadTestSecret(t, secretName, "k8s-ns1")
+		if err != nil {
+			t.Log(err)
+			t.Fatalf("Secret '%s' should be created", secretName)
+		}
+		if _, ok := secret.Annotations[d.versionAnnotationName()]; ok {
+			t.Fatalf("Secret '%s' shouldn't have annotation '%s'", secretName, d.versionAnnotationName())
+		}
+	}
+
+	// Non-versioning secret should be created only with name without cluster suffix
+	k8sSecrets, err := d.k8sClient.CoreV1().Secrets("k8s-ns1").List(k8sMetaV1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret2Objects := []string{}
+	for _, secret := range k8sSecrets.Items {
+		if secret.Annotations[annotationName] == vaultSecretsPath+"/k8s-ns1/"+tvsd.secretsList[1] {
+			secret2Objects = append(secret2Objects, secret.Name)
+		}
+	}
+	if len(secret2Objects) != 1 || secret2Objects[0] != "secret2" {
+		t.Fatalf("Expected only k8s secret 'secret2' for Vault secret '%s', got '%v'", tvsd.secretsList[1], secret2Objects)
+	}
+
+	// Secrets should be updated in place
+	if _, err := d.vaultClient.Logical().Write(vaultSecretsPath+"/k8s-ns1/secret1", map[string]interface{}{"testKey-secret1": "newValue"}); err != nil {
+		t.Fatal(err)
+	}
+	d.testRunUpdateSecretsInK8s(t, map[string]int{"secret1": 1}, "k8s-ns1")
+	secret1, _ := d.testK8sServerReadTestSecret(t, "secret1", "k8s-ns1")
+	if string(secret1.Data["testKey-secret1"]) != "newValue" {
+		t.Fatalf("Incorrect value for secret key 'testKey-secret1': '%s'. Expected 'newValue'", secret1.Data["testKey-secret1"])
+	}
+}
+
+// Test create typed secrets in k8s
+func TestUpdateSecretsInK8sSecretsTypes(t *testing.T) {
+	d := &vtkData{}
+	tvsd := d.testVaultServer(t)
+	defer tvsd.server.Close()
+	_ = d.testK8sServer(t)
+	vaultSecretsPath = tvsKV1MountPath + "/k8s/dev"
+	defer defineAppInitParams()
+	d.kvVersion = 1
+
+	vaultSecrets := map[string]map[string]interface{}{
+		"tls-secret":    {"tls.crt": "testCrt", "tls.key": "testKey"},
+		"docker-secret": {".dockerconfigjson": "{\"auths\":{}}"},
+		"broken-docker": {".dockerconfigjson": "notJSON"},
+		"plain-secret":  {"testKey": "testValue"},
+	}
+	for name, data := range vaultSecrets {
+		if _, err := d.vaultClient.Logical().Write(vaultSecretsPath+"/k8s-ns1/"+name, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Without types all secrets are 'Opaque'
+	numWorkers = 2
+	d.testRunUpdateSecretsInK8s(t, map[string]int{"tls-secret": 1}, "k8s-ns1")
+	secret, err := d.testK8sServerReadTestSecret(t, "tls-secret", "k8s-ns1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !secretTypeEqual(secret.Type, k8sCoreV1.SecretTypeOpaque) {
+		t.Fatalf("Incorrect type '%s' of secret, expected '%s'", secret.Type, k8sCoreV1.SecretTypeOpaque)
+	}
+
+	// With types secret should be recreated with new type
+	secretsTypes = "true"
+	d.testRunUpdateSecretsInK8s(t, map[string]int{"tls-secret": 1, "docker-secret": 1, "broken-docker": 1, "plain-secret": 1}, "k8s-ns1")
+	expectedTypes := map[string]k8sCoreV1.SecretType{
+		"tls-secret":    k8sCoreV1.SecretTypeTLS,
+		"docker-secret": k8sCoreV1.SecretTypeDockerConfigJson,
+		"plain-secret":  k8sCoreV1.SecretTypeOpaque,
+	}
+	for name, expectedType := range expectedTypes {
+		secret, err := d.testK8sServerReadTestSecret(t, name, "k8s-ns1")
+		if err != nil {
+			t.Log(err)
+			t.Fatalf("Secret '%s' should be created", name)
+		}
+		if !secretTypeEqual(secret.Type, expectedType) {
+			t.Fatalf("Incorrect type '%s' of secret '%s', expected '%s'", secret.Type, name, expectedType)
+		}
+	}
+	if _, err := d.testK8sServerReadTestSecret(t, "broken-docker", "k8s-ns1"); err == nil {
+		t.Fatal("Secret 'broken-docker' shouldn't be created")
+	}
+}
+
+// Test recreate of existing version of k8s secret when its type was changed
+func TestUpdateSecretsInK8sSecretsTypesVersioning(t *testing.T) {
+	d := &vtkData{}
+	tvsd := d.testVaultServer(t)
+	defer tvsd.server.Close()
+	_ = d.testK8sServer(t)
+	defer defineAppInitParams()
+	metadataChangeDetection = "true"
+	defer func() { metadataChangeDetection = "false" }()
+
+	tvsMountPath := strings.SplitN(vaultSecretsPath, "/", 2)[0] + "/data/" + strings.SplitN(vaultSecretsPath, "/", 2)[1]
+	if _, err := d.vaultClient.Logical().Write(tvsMountPath+"/k8s-ns1/tls-secret", map[string]interface{}{"data": map[string]interface{}{"tls.crt": "testCrt", "tls.key": "testKey"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Version is created as 'Opaque' without types
+	numWorkers = 1
+	d.testRunUpdateSecretsInK8s(t, map[string]int{"tls-secret": 1}, "k8s-ns1")
+	secret, err := d.testK8sServerReadTestSecret(t, "tls-secret-v1", "k8s-ns1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !secretTypeEqual(secret.Type, k8sCoreV1.SecretTypeOpaque) {
+		t.Fatalf("Incorrect type '%s' of secret, expected '%s'", secret.Type, k8sCoreV1.SecretTypeOpaque)
+	}
+
+	// Existing version should be recreated with new type
+	secretsTypes = "true"
+	d.testRunUpdateSecretsInK8s(t, map[string]int{"tls-secret": 1}, "k8s-ns1")
+	secret, err = d.testK8sServerReadTestSecret(t, "tls-secret-v1", "k8s-ns1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !secretTypeEqual(secret.Type, k8sCoreV1.SecretTypeTLS) {
+		t.Fatalf("Incorrect type '%s' of secret, expected '%s'", secret.Type, k8sCoreV1.SecretTypeTLS)
+	}
+
+	// Version with the same type is up-to-date
+	metadata, err := d.secretsReadMetadata(vaultSecretsPath + "/k8s-ns1/tls-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upToDate, err := d.k8sSecretsUpToDate("k8s-ns1", vaultSecretsPath+"/k8s-ns1/tls-secret", "."+k8sClusterName, secretForUpdate{name: "tls-secret", versioning: 1}, metadata, []string{"tls-secret-v1"}, false)
+	if err != nil || !upToDate {
+		t.Fatalf("Secret 'tls-secret-v1' should be up-to-date, got '%t', '%v'", upToDate, err)
+	}
+}
+
+// Test list secrets from Vault subdirectories
+func TestSecretsListRecursive(t *testing.T) {
+	d := &vtkData{}
+	tvsd := d.testVaultServer(t)
+	defer tvsd.server.Close()
+	_ = d.testK8sServer(t)
+	d.testVaultServerCreateSecrets(t, []string{"db/primary", "db/replica." + k8sClusterName, "db/deep/secret7"}, "k8s-ns1")
+
+	// Default depth
+	listSecrets, err := d.secretsList("k8s-ns1")
+	if err != nil {
+		t.Log(err)
+		t.Fatal("Error should not be raised")
+	}
+	if result := checkItemInArray(listSecrets, "db/primary"); result != false {
+		t.Fatal("Secret 'db/primary' found in response")
+	}
+
+	secretsMaxDepth = 2
+	defer defineAppInitParams()
+	listSecrets, err = d.secretsList("k8s-ns1")
+	if err != nil {
+		t.Log(err)
+		t.Fatal("Error should not be raised")
+	}
+	if result := checkItemInArray(listSecrets, "secret1"); result != true {
+		t.Fatal("Secret 'secret1' didn't find in response")
+	}
+	if result := checkItemInArray(listSecrets, "db/primary"); result != true {
+		t.Fatal("Secret 'db/primary' didn't find in response")
+	}
+	if result := checkItemInArray(listSecrets, "db/deep/secret7"); result != false {
+		t.Fatal("Secret 'db/deep/secret7' found in response, but it's deeper than 'SECRETS_MAX_DEPTH'")
+	}
+
+	// Secrets from subdirectories are filtered by name
+	filteredSecrets := d.filterSecrets(listSecrets, "."+k8sClusterName, "k8s-ns-nonver")
+	if filteredSecrets["db/replica."+k8sClusterName] != 0 {
+		t.Fatalf("Secret 'db/replica.%s' is versioning, but should be non-versioning", k8sClusterName)
+	}
+
+	numWorkers = 1
+	d.testRunUpdateSecretsInK8s(t, filteredSecrets, "k8s-ns1")
+
+	// Path to secret should be joined in k8s secret name and kept in annotation
+	secretPrimary, err := d.testK8sServerReadTestSecret(t, "db-primary-v1", "k8s-ns1")
+	if err != nil {
+		t.Log(err)
+		t.Fatal("Error should not be raised")
+	}
+	if secretPrimary.Annotations[annotationName] != vaultSecretsPath+"/k8s-ns1/db/primary" {
+		t.Fatalf("Incorrect value for secret annotation '%s': '%s'. Expected '%s'", annotationName, secretPrimary.Annotations[annotationName], vaultSecretsPath+"/k8s-ns1/db/primary")
+	}
+	if _, err := d.testK8sServerReadTestSecret(t, "db-replica", "k8s-ns1"); err != nil {
+		t.Log(err)
+		t.Fatal("Error should not be raised")
+	}
+}
+
+// Test sync of namespace is stopped without deadlock if workers return errors
+func TestSyncNamespaceWorkersErrors(t *testing.T) {
+	d := &vtkData{}
+	tvsd := d.testVaultServer(t)
+	defer tvsd.server.Close()
+	_ = d.testK8sServer(t)
+	defer defineAppInitParams()
+
+	// Non-versioning secrets which should be updated
+	secretsList := []string{"app1", "app2", "app3", "app4", "app5", "app6"}
+	for _, secretName := range secretsList {
+		d.testVaultServerCreateSecrets(t, []string{secretName + "." + k8sClusterName}, "k8s-ns-nonver")
+		d.testK8sServerCreateSecret(t, secretName, "k8s-ns-nonver", annotationName, vaultSecretsPath+"/k8s-ns-nonver/"+secretName+"."+k8sClusterName)
+	}
+	updateSecretsReactor := func(action k8sTesting.Action) (handled bool, ret runtime.Object, err error) {
+		return true, nil, errors.New("update isn't allowed")
+	}
+	d.k8sClient.(*fake.Clientset).PrependReactor("update", "secrets", updateSecretsReactor)
+
+	numWorkers = 2
+	syncStopped := make(chan bool)
+	go func() {
+		syncStopped <- d.syncNamespace(context.Background(), "k8s-ns-nonver", "k8s-ns-nonver", "."+k8sClusterName)
+	}()
+	select {
+	case status := <-syncStopped:
+		if status {
+			t.Fatal("Sync of namespace should be unsuccessful")
+		}
+	case <-time.After(time.Second * 10):
+		t.Fatal("Sync of namespace should be stopped after error")
+	}
+}
+
+// Test sync of namespace isn't started if application is stopping
+func TestSyncNamespaceCanceled(t *testing.T) {
+	d := &vtkData{}
+	tvsd := d.testVaultServer(t)
+	defer tvsd.server.Close()
+	_ = d.testK8sServer(t)
+	defer defineAppInitParams()
+
+	d.testVaultServerCreateSecrets(t, tvsd.secretsList, "k8s-ns1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	numWorkers = 2
+	if d.syncNamespace(ctx, "k8s-ns1", "k8s-ns1", "."+k8sClusterName) {
+		t.Fatal("Sync of namespace should be unsuccessful")
+	}
+	k8sSecrets, err := d.k8sSecretsList("k8s-ns1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(k8sSecrets) != 0 {
+		t.Fatalf("Secrets shouldn't be created, but got '%v'", k8sSecrets)
+	}
+}
+
+// Test sync cycle of all namespaces and its result in health state
+func TestSyncCycle(t *testing.T) {
+	d := &vtkData{}
+	tvsd := d.testVaultServer(t)
+	defer tvsd.server.Close()
+	_ = d.testK8sServer(t)
+	defer defineAppInitParams()
+	defer func() { health = &healthState{} }()
+	health = &healthState{}
+
+	d.testVaultServerCreateSecrets(t, tvsd.secretsList, "k8s-ns1")
+	numWorkers = 2
+
+	// Sync is unsuccessful if there are no namespaces for sync
+	if d.syncCycle(context.Background()) {
+		t.Fatal("Sync should be unsuccessful without namespaces in k8s")
+	}
+	if !health.lastSyncFailed || !health.lastSuccessfulSync.IsZero() {
+		t.Fatal("Sync cycle should be failed in health state")
+	}
+
+	namespace := &k8sCoreV1.Namespace{}
+	namespace.Name = "k8s-ns1"
+	if _, err := d.k8sClient.CoreV1().Namespaces().Create(namespace); err != nil {
+		t.Fatal(err)
+	}
+	if !d.syncCycle(context.Background()) {
+		t.Fatal("Sync should be successful")
+	}
+	if health.lastSyncFailed || health.lastSuccessfulSync.IsZero() {
+		t.Fatal("Sync cycle should be successful in health state")
+	}
+	k8sSecrets, err := d.k8sSecretsList("k8s-ns1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(k8sSecrets) == 0 {
+		t.Fatal("Secrets should be created during sync")
+	}
+}
+
+// Test dry run doesn't change credentials and application system secret
+func TestRunDryRun(t *testing.T) {
+	d := &vtkData{}
+	tvsd := d.testVaultServer(t)
+	defer tvsd.server.Close()
+	tksd := d.testK8sServer(t)
+	defer defineAppInitParams()
+	dryRun = "true"
+	once = true
+	numWorkers = 2
+
+	// Credentials of running application
+	rootToken := d.vaultClient.Token()
+	d.testVaultServerFetchToken(t)
+	tksd.systemSecretTokenAccessor = d.vaultTokenAccessor
+	tksd.systemSecretAppRoleSecretID = fmt.Sprintf("%s", d.approleSecretID)
+	d.testK8sServerCreateSystemSecret(t, tksd, "none")
+	d.approleSecretID = nil
+	d.vaultTokenAccessor = ""
+	namespace := &k8sCoreV1.Namespace{}
+	namespace.Name = "k8s-ns1"
+	if _, err := d.k8sClient.CoreV1().Namespaces().Create(namespace); err != nil {
+		t.Fatal(err)
+	}
+	d.k8sClient.(*fake.Clientset).ClearActions()
+
+	if !d.run(context.Background()) {
+		t.Fatal("Dry run should be successful")
+	}
+
+	for _, action := range d.k8sClient.(*fake.Clientset).Actions() {
+		if action.GetVerb() != "get" && action.GetVerb() != "list" {
+			t.Fatalf("Dry run shouldn't change k8s objects, got '%s' of '%s'", action.GetVerb(), action.GetResource().Resource)
+		}
+	}
+
+	// Credentials of running application aren't revoked, token of dry run is revoked
+	d.vaultClient.SetToken(rootToken)
+	if _, err := d.vaultClient.Auth().Token().LookupAccessor(tksd.systemSecretTokenAccessor); err != nil {
+		t.Fatal("Token of running application shouldn't be revoked:", err)
+	}
+	lookupPath := fmt.Sprintf("auth/approle/role/%s/secret-id/lookup", tvsAppRoleName)
+	secretID, err := d.vaultClient.Logical().Write(lookupPath, map[string]interface{}{"secret_id": tksd.systemSecretAppRoleSecretID})
+	if err != nil || secretID == nil {
+		t.Fatal("Secret ID of running application shouldn't be revoked:", err)
+	}
+	if _, err := d.vaultClient.Auth().Token().LookupAccessor(d.vaultTokenAccessor); err == nil {
+		t.Fatal("Token of dry run should be revoked")
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// Rule of mapping Vault directory to k8s namespaces
+type namespaceMappingRule struct {
+	vaultDir   string         // Name of Vault directory, empty for regex rule
+	regex      *regexp.Regexp // Regex for names of Vault directories
+	namespaces []string       // Names (templates for regex rule) of k8s namespaces
+}
+
+// Vault directory and k8s namespace to which its secrets are synced
+type namespaceMapping struct {
+	vaultDir  string
+	namespace string
+}
+
+// Mapping rule from configuration file
+type namespaceMappingConfig struct {
+	VaultDir      string   `json:"vault_dir"`
+	VaultDirRegex string   `json:"vault_dir_regex"`
+	Namespaces    []string `json:"namespaces"`
+}
+
+// Split rules of mapping by comma, commas inside braces, brackets and parentheses belong to regex (for example, '{2,4}')
+func splitNamespacesMapping(mapping string) []string {
+	rules := []string{}
+	depth := 0
+	escaped := false
+	start := 0
+	for i, c := range mapping {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '{' || c == '[' || c == '(':
+			depth++
+		case (c == '}' || c == ']' || c == ')') && depth > 0:
+			depth--
+		case c == ',' && depth == 0:
+			rules = append(rules, mapping[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(rules, mapping[start:])
+}
+
+// Parse rules of mapping Vault directories to k8s namespaces, format: '<vault dir>=<namespace>[|<namespace>]' separated by comma, vault dir with '~' prefix is regex
+func parseNamespacesMapping(mapping string) ([]namespaceMappingRule, error) {
+	configs := []namespaceMappingConfig{}
+	if mapping == "" {
+		return nil, nil
+	}
+	for _, rule := range splitNamespacesMapping(mapping) {
+		ruleParams := strings.SplitN(rule, "=", 2)
+		if len(ruleParams) != 2 {
+			return nil, fmt.Errorf("Incorrect rule '%s' in 'NAMESPACES_MAPPING', should be '<vault dir>=<namespace>[|<namespace>]'", rule)
+		}
+		config := namespaceMappingConfig{Namespaces: strings.Split(ruleParams[1], "|")}
+		if strings.HasPrefix(ruleParams[0], "~") {
+			config.VaultDirRegex = strings.TrimPrefix(ruleParams[0], "~")
+		} else {
+			config.VaultDir = ruleParams[0]
+		}
+		configs = append(configs, config)
+	}
+
+	return namespacesMappingRules(configs)
+}
+
+// Make rules of mapping Vault directories to k8s namespaces
+func namespacesMappingRules(configs []namespaceMappingConfig) ([]namespaceMappingRule, error) {
+	rules := []namespaceMappingRule{}
+	for _, config := range configs {
+		if (config.VaultDir == "") == (config.VaultDirRegex == "") {
+			return nil, fmt.Errorf("Exactly one of Vault directory or regex should be defined in namespaces mapping rule")
+		}
+		if len(config.Namespaces) == 0 {
+			return nil, fmt.Errorf("Namespaces aren't defined in mapping rule for '%s%s'", config.VaultDir, config.VaultDirRegex)
+		}
+		for _, namespace := range config.Namespaces {
+			if namespace == "" {
+				return nil, fmt.Errorf("Empty namespace in mapping rule for '%s%s'", config.VaultDir, config.VaultDirRegex)
+			}
+		}
+		rule := namespaceMappingRule{vaultDir: config.VaultDir, namespaces: config.Namespaces}
+		if config.VaultDirRegex != "" {
+			// Regex should match the whole name of Vault directory
+			regex, err := regexp.Compile("^(?:" + config.VaultDirRegex + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("Incorrect regex '%s' in namespaces mapping rule: %s", config.VaultDirRegex, err)
+			}
+			rule.regex = regex
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// k8s namespaces for Vault directory by the first matched rule, namespace with the same name if there are no matched rules
+func (d *vtkData) mapNamespaces(vaultDir string) []string {
+	for _, rule := range d.namespacesMapping {
+		if rule.regex == nil {
+			if rule.vaultDir == vaultDir {
+				return rule.namespaces
+			}
+			continue
+		}
+		match := rule.regex.FindStringSubmatchIndex(vaultDir)
+		if match == nil {
+			continue
+		}
+		namespaces := []string{}
+		for _, template := range rule.namespaces {
+			namespaces = append(namespaces, string(rule.regex.ExpandString(nil, template, vaultDir, match)))
+		}
+		return namespaces
+	}
+
+	return []string{vaultDir}
+}
+
+// Map Vault directories to k8s namespaces, each k8s namespace can be synced only from one Vault directory
+func (d *vtkData) mapVaultDirs(vaultDirs []string) []namespaceMapping {
+	mappings := []namespaceMapping{}
+	mappedNamespaces := make(map[string]string)
+	for _, vaultDir := range vaultDirs {
+		for _, namespace := range d.mapNamespaces(vaultDir) {
+			if mappedVaultDir, ok := mappedNamespaces[namespace]; ok {
+				glog.Warningln("Namespace '" + namespace + "' is already mapped to Vault directory '" + mappedVaultDir + "', Vault directory '" + vaultDir + "' is ignored for it")
+				continue
+			}
+			mappedNamespaces[namespace] = vaultDir
+			mappings = append(mappings, namespaceMapping{vaultDir: vaultDir, namespace: namespace})
+		}
+	}
+
+	return mappings
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	k8sCoreV1 "k8s.io/api/core/v1"
+)
+
+// Test parse rules of namespaces mapping
+func TestParseNamespacesMapping(t *testing.T) {
+	rules, err := parseNamespacesMapping("")
+	if err != nil || len(rules) != 0 {
+		t.Fatalf("Expected no rules for empty mapping, got '%v' and error '%v'", rules, err)
+	}
+
+	d := &vtkData{}
+	d.namespacesMapping, err = parseNamespacesMapping("team-a=team-a-prod|team-a-canary,legacy=apps,~svc-(.+)=${1}-prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string][]string{
+		"team-a":     {"team-a-prod", "team-a-canary"},
+		"legacy":     {"apps"},
+		"svc-web":    {"web-prod"},
+		"svc-":       {"svc-"},       // Regex should match the whole name
+		"my-svc-web": {"my-svc-web"}, // Regex is anchored
+		"k8s-ns1":    {"k8s-ns1"},
+	}
+	for vaultDir, expected := range tests {
+		if namespaces := d.mapNamespaces(vaultDir); !reflect.DeepEqual(namespaces, expected) {
+			t.Fatalf("Expected namespaces '%v' for '%s' Vault directory, got '%v'", expected, vaultDir, namespaces)
+		}
+	}
+}
+
+// Test parse rules of namespaces mapping with regex quantifiers and alternations
+func TestParseNamespacesMappingQuantifier(t *testing.T) {
+	var err error
+	d := &vtkData{}
+	d.namespacesMapping, err = parseNamespacesMapping("~^team-[a-z]{2,4}$=${0}-prod,~svc-(web|api)=apps,legacy=legacy-prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.namespacesMapping) != 3 {
+		t.Fatalf("Expected 3 rules, got '%d'", len(d.namespacesMapping))
+	}
+
+	tests := map[string][]string{
+		"team-ab":     {"team-ab-prod"},
+		"team-abcd":   {"team-abcd-prod"},
+		"team-abcde":  {"team-abcde"},
+		"svc-api":     {"apps"},
+		"legacy":      {"legacy-prod"},
+		"team-a-prod": {"team-a-prod"},
+	}
+	for vaultDir, expected := range tests {
+		if namespaces := d.mapNamespaces(vaultDir); !reflect.DeepEqual(namespaces, expected) {
+			t.Fatalf("Expected namespaces '%v' for '%s' Vault directory, got '%v'", expected, vaultDir, namespaces)
+		}
+	}
+}
+
+// Test errors of incorrect rules of namespaces mapping
+func TestParseNamespacesMappingErrors(t *testing.T) {
+	tests := map[string]string{
+		"Incorrect rule":       "team-a",
+		"Empty namespace":      "team-a=team-a-prod|",
+		"Incorrect regex":      "~team-(=team",
+		"Exactly one of Vault": "=team-a",
+	}
+	for expectedErr, mapping := range tests {
+		if _, err := parseNamespacesMapping(mapping); err == nil || !strings.Contains(err.Error(), expectedErr) {
+			t.Fatalf("Expected error '%s' for mapping '%s', got '%v'", expectedErr, mapping, err)
+		}
+	}
+}
+
+// Test map Vault directories to k8s namespaces
+func TestMapVaultDirs(t *testing.T) {
+	var err error
+	d := &vtkData{}
+	d.namespacesMapping, err = parseNamespacesMapping("team-a=team-a-prod|team-b,~(.*)-old=${1}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Namespace is synced only from the first Vault directory which is mapped to it
+	mappings := d.mapVaultDirs([]string{"team-a", "team-b", "team-c-old", "team-c"})
+	expected := []namespaceMapping{
+		{vaultDir: "team-a", namespace: "team-a-prod"},
+		{vaultDir: "team-a", namespace: "team-b"},
+		{vaultDir: "team-c-old", namespace: "team-c"},
+	}
+	if !reflect.DeepEqual(mappings, expected) {
+		t.Fatalf("Expected mappings '%v', got '%v'", expected, mappings)
+	}
+}
+
+// Test sync of Vault directory to mapped k8s namespaces
+func TestSyncNamespacesMapping(t *testing.T) {
+	var err error
+	d := &vtkData{}
+	tvsd := d.testVaultServer(t)
+	defer tvsd.server.Close()
+	_ = d.testK8sServer(t)
+	defer defineAppInitParams()
+	numWorkers = 2
+
+	d.testVaultServerCreateSecrets(t, []string{"app"}, "team-a")
+	d.namespacesMapping, err = parseNamespacesMapping("team-a=team-a-prod|team-a-canary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"team-a-prod", "team-a-canary"} {
+		namespace := &k8sCoreV1.Namespace{}
+		namespace.Name = name
+		if _, err := d.k8sClient.CoreV1().Namespaces().Create(namespace); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !d.syncCycle(context.Background()) {
+		t.Fatal("Sync should be successful")
+	}
+
+	// Secrets of Vault directory are synced to all mapped namespaces
+	for _, namespace := range []string{"team-a-prod", "team-a-canary"} {
+		secret, err := d.testK8sServerReadTestSecret(t, "app-v1", namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if secret.Annotations[annotationName] != vaultSecretsPath+"/team-a/app" {
+			t.Fatalf("Incorrect annotations of secret 'app-v1' in '%s' namespace: '%v'", namespace, secret.Annotations)
+		}
+		if status := readMetricValue(syncStatus.WithLabelValues("", namespace)); status != 1 {
+			t.Fatalf("Expected sync status '1' for '%s' namespace, got '%v'", namespace, status)
+		}
+	}
+}
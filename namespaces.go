@@ -0,0 +1,49 @@
+package main
+
+import (
+	k8sCoreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Reasons of exclusion of k8s namespaces from sync
+const (
+	excludedDenyList      = "deny_list"
+	excludedLabelSelector = "label_selector"
+	excludedOptIn         = "opt_in"
+	excludedTerminating   = "terminating"
+)
+
+// Reason why k8s namespace isn't eligible for sync, empty if it's eligible
+func (d *vtkData) namespaceExclusionReason(namespace *k8sCoreV1.Namespace) string {
+	if d.deniedNamespaces[namespace.Name] {
+		return excludedDenyList
+	}
+	if d.namespacesSelector != nil && !d.namespacesSelector.Matches(labels.Set(namespace.Labels)) {
+		return excludedLabelSelector
+	}
+	if namespacesOptInAnnotation != "" && namespace.Annotations[namespacesOptInAnnotation] != "true" {
+		return excludedOptIn
+	}
+	// Objects can't be created in namespace which is being deleted
+	if namespace.Status.Phase == k8sCoreV1.NamespaceTerminating {
+		return excludedTerminating
+	}
+
+	return ""
+}
+
+// Expose number of namespaces with Vault secrets which were excluded from sync by reason
+func setNamespacesExcluded(excluded map[string]string) {
+	reasons := map[string]float64{
+		excludedDenyList:      0,
+		excludedLabelSelector: 0,
+		excludedOptIn:         0,
+		excludedTerminating:   0,
+	}
+	for _, reason := range excluded {
+		reasons[reason]++
+	}
+	for reason, count := range reasons {
+		namespacesExcluded.WithLabelValues(reason).Set(count)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	k8sCoreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Test list of k8s namespaces which are eligible for sync
+func TestK8sNamespacesList(t *testing.T) {
+	var err error
+	d := &vtkData{}
+	_ = d.testK8sServer(t)
+	defer func() { namespacesOptInAnnotation = "" }()
+
+	d.deniedNamespaces = map[string]bool{"kube-system": true}
+	d.namespacesSelector, err = labels.Parse("team=a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	namespacesOptInAnnotation = "vault-to-k8s/sync"
+
+	tests := []struct {
+		name        string
+		labels      map[string]string
+		annotations map[string]string
+		phase       k8sCoreV1.NamespacePhase
+	}{
+		{"k8s-ns1", map[string]string{"team": "a"}, map[string]string{"vault-to-k8s/sync": "true"}, k8sCoreV1.NamespaceActive},
+		{"kube-system", map[string]string{"team": "a"}, map[string]string{"vault-to-k8s/sync": "true"}, k8sCoreV1.NamespaceActive},
+		{"k8s-ns2", map[string]string{"team": "b"}, map[string]string{"vault-to-k8s/sync": "true"}, k8sCoreV1.NamespaceActive},
+		{"k8s-ns3", map[string]string{"team": "a"}, map[string]string{"vault-to-k8s/sync": "false"}, k8sCoreV1.NamespaceActive},
+		{"k8s-ns4", map[string]string{"team": "a"}, map[string]string{"vault-to-k8s/sync": "true"}, k8sCoreV1.NamespaceTerminating},
+	}
+	for _, test := range tests {
+		namespace := &k8sCoreV1.Namespace{}
+		namespace.Name = test.name
+		namespace.Labels = test.labels
+		namespace.Annotations = test.annotations
+		namespace.Status.Phase = test.phase
+		if _, err := d.k8sClient.CoreV1().Namespaces().Create(namespace); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	k8sNamespaces, excludedNamespaces, err := d.k8sNamespacesList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(k8sNamespaces, []string{"k8s-ns1"}) {
+		t.Fatalf("Expected only 'k8s-ns1' namespace for sync, got '%v'", k8sNamespaces)
+	}
+	expected := map[string]string{
+		"kube-system": excludedDenyList,
+		"k8s-ns2":     excludedLabelSelector,
+		"k8s-ns3":     excludedOptIn,
+		"k8s-ns4":     excludedTerminating,
+	}
+	if !reflect.DeepEqual(excludedNamespaces, expected) {
+		t.Fatalf("Expected excluded namespaces '%v', got '%v'", expected, excludedNamespaces)
+	}
+}
+
+// Test excluded namespaces aren't synced and are reported separately from namespaces which don't exist
+func TestNamespacesForSyncExcluded(t *testing.T) {
+	d := &vtkData{}
+
+	// Excluded namespaces are reported separately from namespaces which don't exist
+	syncStatus.WithLabelValues("", "kube-system").Set(1)
+	excluded := make(map[string]string)
+	nsForSync := d.namespacesForSync([]string{"k8s-ns1", "k8s-ns2", "kube-system", "k8s-ns4"}, []string{"k8s-ns1"}, map[string]string{"kube-system": excludedDenyList, "k8s-ns4": excludedTerminating}, excluded)
+	if !reflect.DeepEqual(nsForSync, []namespaceMapping{{vaultDir: "k8s-ns1", namespace: "k8s-ns1"}}) {
+		t.Fatalf("Expected only 'k8s-ns1' namespace for sync, got '%v'", nsForSync)
+	}
+	if status := readMetricValue(syncStatus.WithLabelValues("", "k8s-ns2")); status != 0 {
+		t.Fatalf("Expected sync status '0' for 'k8s-ns2' namespace, got '%v'", status)
+	}
+	if syncStatus.DeleteLabelValues("", "kube-system") {
+		t.Fatal("Sync status of excluded namespace should be removed")
+	}
+	setNamespacesExcluded(excluded)
+	for reason, expected := range map[string]float64{excludedDenyList: 1, excludedTerminating: 1, excludedLabelSelector: 0, excludedOptIn: 0} {
+		if count := readMetricValue(namespacesExcluded.WithLabelValues(reason)); count != expected {
+			t.Fatalf("Expected '%v' namespaces excluded by '%s', got '%v'", expected, reason, count)
+		}
+	}
+}
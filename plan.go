@@ -0,0 +1,102 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/golang/glog"
+)
+
+// Actions for k8s objects
+const (
+	actionCreate   = "create"
+	actionUpdate   = "update"
+	actionRecreate = "recreate"
+	actionSkip     = "skip"
+	actionNone     = "none"
+)
+
+// Kinds of k8s objects
+const (
+	kindSecret    = "secret"
+	kindConfigMap = "configmap"
+)
+
+// Planned action for k8s object
+type planEntry struct {
+	kind      string // Kind of k8s object
+	name      string // Name of k8s object, empty if action was chosen before k8s object was defined
+	vaultPath string // Path of Vault secret
+	action    string // Action for k8s object
+	reason    string // Why this action was chosen
+}
+
+// Decide what should be done with k8s object for Vault secret
+func (d *vtkData) k8sObjectAction(vaultSecretPathFull string, exists bool, annotations map[string]string, upToDate bool, typeChange string) (string, string) {
+	if !exists {
+		return actionCreate, "doesn't exist"
+	}
+	// Ownership is checked before data, k8s objects of other owners aren't reported as synced
+	if _, ok := annotations[d.annotationName()]; !ok {
+		return actionSkip, "not managed by '" + appName + "' application (annotation '" + d.annotationName() + "' is missing)"
+	}
+	if annotations[d.annotationName()] != vaultSecretPathFull {
+		return actionSkip, "has annotation '" + d.annotationName() + "' with different path '" + annotations[d.annotationName()] + "'"
+	}
+	if upToDate {
+		return actionNone, "already up-to-date"
+	}
+	if typeChange != "" {
+		return actionRecreate, typeChange
+	}
+
+	return actionUpdate, "data or metadata was changed"
+}
+
+// Add planned action to results
+func (r *updateSecretResults) addPlan(name, vaultPath, action, reason string) {
+	kind := kindSecret
+	if r.configMap {
+		kind = kindConfigMap
+	}
+	r.plan = append(r.plan, planEntry{
+		kind:      kind,
+		name:      name,
+		vaultPath: vaultPath,
+		action:    action,
+		reason:    reason,
+	})
+}
+
+// Log planned actions for namespace and expose number of them in metrics
+func (d *vtkData) logPlan(namespace string, plan []planEntry) {
+	sort.Slice(plan, func(i, j int) bool {
+		if plan[i].action != plan[j].action {
+			return plan[i].action < plan[j].action
+		}
+		return plan[i].name < plan[j].name
+	})
+
+	actions := map[string]float64{
+		actionCreate:   0,
+		actionUpdate:   0,
+		actionRecreate: 0,
+		actionSkip:     0,
+		actionNone:     0,
+	}
+	for _, entry := range plan {
+		actions[entry.action]++
+		object := entry.kind + " '" + entry.name + "' from '" + entry.vaultPath + "'"
+		if entry.name == "" {
+			object = "Vault secret '" + entry.vaultPath + "'"
+		}
+		if entry.action == actionNone {
+			glog.V(2).Infoln("[DRY-RUN] '" + namespace + "' namespace: " + object + " " + entry.reason)
+			continue
+		}
+		glog.Infoln("[DRY-RUN] '" + namespace + "' namespace: would-" + entry.action + " " + object + ": " + entry.reason)
+	}
+	for action, count := range actions {
+		dryRunPlan.WithLabelValues(d.sourceName(), namespace, action).Set(count)
+	}
+	glog.Infof("[DRY-RUN] '%s' namespace: would-create %.0f, would-update %.0f, would-recreate %.0f, would-skip %.0f, up-to-date %.0f", namespace, actions[actionCreate], actions[actionUpdate], actions[actionRecreate], actions[actionSkip], actions[actionNone])
+}
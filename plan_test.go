@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+
+	k8sCoreV1 "k8s.io/api/core/v1"
+	k8sMetaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Test action which should be done with k8s object
+func TestK8sObjectAction(t *testing.T) {
+	d := &vtkData{}
+	defer defineAppInitParams()
+	path := vaultSecretsPath + "/k8s-ns1/secret1"
+
+	tests := []struct {
+		exists      bool
+		annotations map[string]string
+		upToDate    bool
+		typeChange  string
+		action      string
+	}{
+		{false, nil, false, "", actionCreate},
+		{true, map[string]string{annotationName: path}, true, "", actionNone},
+		{true, map[string]string{}, false, "", actionSkip},
+		{true, map[string]string{}, true, "", actionSkip},
+		{true, map[string]string{annotationName: vaultSecretsPath + "/k8s-ns1/secret2"}, true, "", actionSkip},
+		{true, map[string]string{annotationName: vaultSecretsPath + "/k8s-ns1/secret2"}, false, "", actionSkip},
+		{true, map[string]string{annotationName: path}, false, "type was changed", actionRecreate},
+		{true, map[string]string{annotationName: path}, false, "", actionUpdate},
+	}
+	for i, test := range tests {
+		action, reason := d.k8sObjectAction(path, test.exists, test.annotations, test.upToDate, test.typeChange)
+		if action != test.action {
+			t.Fatalf("Test %d: expected '%s' action, got '%s' (%s)", i, test.action, action, reason)
+		}
+		if reason == "" {
+			t.Fatalf("Test %d: reason of '%s' action shouldn't be empty", i, action)
+		}
+	}
+}
+
+// Test k8s secrets aren't changed in dry-run and planned actions are returned
+func TestUpdateSecretInK8sDryRun(t *testing.T) {
+	d := &vtkData{}
+	tvsd := d.testVaultServer(t)
+	defer tvsd.server.Close()
+	_ = d.testK8sServer(t)
+	defer defineAppInitParams()
+	dryRun = "true"
+
+	d.testVaultServerCreateSecrets(t, []string{"app-new", "app-unmanaged", "app-other"}, "k8s-ns1")
+	existingSecrets := map[string]map[string]string{
+		"app-unmanaged-v1": {},
+		"app-other-v1":     {annotationName: vaultSecretsPath + "/k8s-ns1/app-new"},
+	}
+	for name, annotations := range existingSecrets {
+		secret := &k8sCoreV1.Secret{}
+		secret.Name = name
+		secret.Annotations = annotations
+		if _, err := d.k8sClient.CoreV1().Secrets("k8s-ns1").Create(secret); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := map[string]string{
+		"app-new":       actionCreate,
+		"app-unmanaged": actionSkip,
+		"app-other":     actionSkip,
+	}
+	for name, action := range expected {
+		results := d.updateSecretInK8s("", secretForUpdate{name: name, versioning: 1}, "k8s-ns1", "k8s-ns1", "."+k8sClusterName, []string{}, []string{})
+		if results.err != nil {
+			t.Fatal(results.err)
+		}
+		if len(results.plan) != 1 {
+			t.Fatalf("Expected 1 planned action for '%s', got '%v'", name, results.plan)
+		}
+		if results.plan[0].name != name+"-v1" || results.plan[0].action != action {
+			t.Fatalf("Expected '%s' action for '%s-v1', got '%s' for '%s' (%s)", action, name, results.plan[0].action, results.plan[0].name, results.plan[0].reason)
+		}
+		if results.created != 0 || results.updated != 0 {
+			t.Fatalf("Secret '%s' shouldn't be created or updated in dry-run mode", name)
+		}
+	}
+
+	// k8s objects shouldn't be changed
+	if _, err := d.k8sClient.CoreV1().Secrets("k8s-ns1").Get("app-new-v1", k8sMetaV1.GetOptions{}); err == nil {
+		t.Fatal("Secret 'app-new-v1' shouldn't be created in dry-run mode")
+	}
+	for name, annotations := range existingSecrets {
+		secret, err := d.k8sClient.CoreV1().Secrets("k8s-ns1").Get(name, k8sMetaV1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(secret.Data) != 0 || len(secret.Annotations) != len(annotations) {
+			t.Fatalf("Secret '%s' shouldn't be updated in dry-run mode", name)
+		}
+	}
+}
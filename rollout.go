@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	k8sCoreV1 "k8s.io/api/core/v1"
+	k8sMetaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Workload which can be rolled out by change of pod template
+type rolloutWorkload struct {
+	kind        string
+	name        string
+	annotations map[string]string
+	podSpec     *k8sCoreV1.PodSpec
+	object      runtime.Object
+	patch       func(name string, pt types.PatchType, data []byte, subresources ...string) (runtime.Object, error)
+}
+
+// Name of annotation of workloads which opt in for rollout on change of non-versioning secrets
+func (d *vtkData) rolloutAnnotationName() string {
+	return d.annotationName() + "-rollout"
+}
+
+// Name of pod template annotation which is changed for rollout
+func (d *vtkData) rolloutHashAnnotationName() string {
+	return d.annotationName() + "-rollout-hash"
+}
+
+// Hash of k8s secret data, it's different for different secrets with the same data
+func secretDataHash(secretName string, data map[string][]byte) string {
+	keys := []string{}
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	hash := sha256.New()
+	hash.Write([]byte(secretName))
+	for _, k := range keys {
+		hash.Write([]byte{0})
+		hash.Write([]byte(k))
+		hash.Write([]byte{0})
+		hash.Write(data[k])
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// List of Deployments, StatefulSets and DaemonSets in namespace
+func (d *vtkData) k8sRolloutWorkloads(namespace string) ([]rolloutWorkload, error) {
+	workloads := []rolloutWorkload{}
+
+	start := time.Now()
+	deployments, err := d.k8sClient.AppsV1().Deployments(namespace).List(k8sMetaV1.ListOptions{})
+	observeAPIRequest(backendK8s, "list_deployments", start, err)
+	if err != nil {
+		return nil, err
+	}
+	for i := range deployments.Items {
+		v := &deployments.Items[i]
+		workloads = append(workloads, rolloutWorkload{kind: "deployment", name: v.Name, annotations: v.Annotations, podSpec: &v.Spec.Template.Spec, object: v,
+			patch: func(name string, pt types.PatchType, data []byte, subresources ...string) (runtime.Object, error) {
+				return d.k8sClient.AppsV1().Deployments(namespace).Patch(name, pt, data, subresources...)
+			}})
+	}
+
+	start = time.Now()
+	statefulSets, err := d.k8sClient.AppsV1().StatefulSets(namespace).List(k8sMetaV1.ListOptions{})
+	observeAPIRequest(backendK8s, "list_statefulsets", start, err)
+	if err != nil {
+		return nil, err
+	}
+	for i := range statefulSets.Items {
+		v := &statefulSets.Items[i]
+		workloads = append(workloads, rolloutWorkload{kind: "statefulset", name: v.Name, annotations: v.Annotations, podSpec: &v.Spec.Template.Spec, object: v,
+			patch: func(name string, pt types.PatchType, data []byte, subresources ...string) (runtime.Object, error) {
+				return d.k8sClient.AppsV1().StatefulSets(namespace).Patch(name, pt, data, subresources...)
+			}})
+	}
+
+	start = time.Now()
+	daemonSets, err := d.k8sClient.AppsV1().DaemonSets(namespace).List(k8sMetaV1.ListOptions{})
+	observeAPIRequest(backendK8s, "list_daemonsets", start, err)
+	if err != nil {
+		return nil, err
+	}
+	for i := range daemonSets.Items {
+		v := &daemonSets.Items[i]
+		workloads = append(workloads, rolloutWorkload{kind: "daemonset", name: v.Name, annotations: v.Annotations, podSpec: &v.Spec.Template.Spec, object: v,
+			patch: func(name string, pt types.PatchType, data []byte, subresources ...string) (runtime.Object, error) {
+				return d.k8sClient.AppsV1().DaemonSets(namespace).Patch(name, pt, data, subresources...)
+			}})
+	}
+
+	return workloads, nil
+}
+
+// Roll out opted-in workloads which use changed non-versioning secret, returns number of rolled out workloads
+func (d *vtkData) rolloutWorkloads(numWorkerStr, namespace, secretName string, data map[string][]byte) (float64, error) {
+	rolledOut := 0.0
+	workloads, err := d.k8sRolloutWorkloads(namespace)
+	if err != nil {
+		return rolledOut, errors.Wrap(err, "Error during get k8s workloads for rollout")
+	}
+
+	// Pod template annotation is changed, so workload controller creates new pods
+	hash := secretDataHash(secretName, data)
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]string{
+						d.rolloutHashAnnotationName(): hash,
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return rolledOut, err
+	}
+	for _, workload := range workloads {
+		if workload.annotations[d.rolloutAnnotationName()] != "true" {
+			continue
+		}
+		secrets := make(map[string]bool)
+		podSpecSecrets(workload.podSpec, secrets)
+		if !secrets[secretName] {
+			continue
+		}
+		glog.V(2).Infoln(numWorkerStr + "Roll out " + workload.kind + " '" + workload.name + "' in '" + namespace + "' namespace as secret '" + secretName + "' was changed")
+		start := time.Now()
+		_, err := workload.patch(workload.name, types.StrategicMergePatchType, patch)
+		observeAPIRequest(backendK8s, "patch_"+workload.kind, start, err)
+		if err != nil {
+			return rolledOut, errors.Wrap(err, "Error during rollout of k8s "+workload.kind+" '"+workload.name+"'")
+		}
+		d.recordEvent(workload.object, k8sCoreV1.EventTypeNormal, eventRolledOut, "Pod template was updated as secret '"+secretName+"' was changed")
+		rolledOut++
+	}
+
+	return rolledOut, nil
+}
+
+// Roll out workloads if data of non-versioning k8s secret was changed, errors don't fail sync of secret
+func (d *vtkData) rolloutWorkloadsOnChange(numWorkerStr, namespace string, existing, secret *k8sCoreV1.Secret, versioning int, updateResults *updateSecretResults) {
+	if rollout != "true" || versioning != 0 || reflect.DeepEqual(existing.Data, secret.Data) {
+		return
+	}
+	rolledOut, err := d.rolloutWorkloads(numWorkerStr, namespace, secret.Name, secret.Data)
+	if err != nil {
+		glog.Errorln(numWorkerStr+"Error during rollout of workloads for secret '"+secret.Name+"' in '"+namespace+"' namespace:", err)
+		d.recordNamespaceEvent(namespace, k8sCoreV1.EventTypeWarning, eventSyncFailed, "Failed to roll out workloads which use secret '"+secret.Name+"': "+err.Error())
+	}
+	updateResults.rolledOut += rolledOut
+}
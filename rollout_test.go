@@ -0,0 +1,101 @@
+package main
+
+import (
+	"testing"
+
+	k8sAppsV1 "k8s.io/api/apps/v1"
+	k8sCoreV1 "k8s.io/api/core/v1"
+	k8sMetaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Make pod template which uses secret in env
+func testPodTemplate(secretName string) k8sCoreV1.PodTemplateSpec {
+	return k8sCoreV1.PodTemplateSpec{
+		Spec: k8sCoreV1.PodSpec{
+			Containers: []k8sCoreV1.Container{{
+				Name: "app",
+				EnvFrom: []k8sCoreV1.EnvFromSource{{
+					SecretRef: &k8sCoreV1.SecretEnvSource{LocalObjectReference: k8sCoreV1.LocalObjectReference{Name: secretName}},
+				}},
+			}},
+		},
+	}
+}
+
+// Test rollout of workloads which opted in when data of non-versioning secret is changed
+func TestRolloutWorkloadsOnChange(t *testing.T) {
+	d := &vtkData{}
+	tvsd := d.testVaultServer(t)
+	defer tvsd.server.Close()
+	_ = d.testK8sServer(t)
+	defer defineAppInitParams()
+	rollout = "true"
+
+	optIn := map[string]string{d.rolloutAnnotationName(): "true"}
+	deployment := &k8sAppsV1.Deployment{}
+	deployment.Name = "app"
+	deployment.Annotations = optIn
+	deployment.Spec.Template = testPodTemplate("app")
+	if _, err := d.k8sClient.AppsV1().Deployments("k8s-ns1").Create(deployment); err != nil {
+		t.Fatal(err)
+	}
+	statefulSet := &k8sAppsV1.StatefulSet{}
+	statefulSet.Name = "not-opted-in"
+	statefulSet.Spec.Template = testPodTemplate("app")
+	if _, err := d.k8sClient.AppsV1().StatefulSets("k8s-ns1").Create(statefulSet); err != nil {
+		t.Fatal(err)
+	}
+	daemonSet := &k8sAppsV1.DaemonSet{}
+	daemonSet.Name = "other-secret"
+	daemonSet.Annotations = optIn
+	daemonSet.Spec.Template = testPodTemplate("other")
+	if _, err := d.k8sClient.AppsV1().DaemonSets("k8s-ns1").Create(daemonSet); err != nil {
+		t.Fatal(err)
+	}
+
+	d.testVaultServerCreateSecrets(t, []string{"app"}, "k8s-ns1")
+	d.testK8sServerCreateSecret(t, "app", "k8s-ns1", annotationName, vaultSecretsPath+"/k8s-ns1/app")
+
+	// Only opted-in workload which uses changed secret is rolled out
+	results := d.updateSecretInK8s("", secretForUpdate{name: "app", versioning: 0}, "k8s-ns1", "k8s-ns1", "."+k8sClusterName, []string{}, []string{})
+	if results.err != nil {
+		t.Fatal(results.err)
+	}
+	if results.rolledOut != 1 {
+		t.Fatalf("Expected 1 rolled out workload, got '%v'", results.rolledOut)
+	}
+	secret, err := d.testK8sServerReadTestSecret(t, "app", "k8s-ns1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deployment, err = d.k8sClient.AppsV1().Deployments("k8s-ns1").Get("app", k8sMetaV1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash := deployment.Spec.Template.Annotations[d.rolloutHashAnnotationName()]; hash != secretDataHash("app", secret.Data) {
+		t.Fatalf("Incorrect rollout hash of deployment 'app': '%s'", hash)
+	}
+	statefulSet, err = d.k8sClient.AppsV1().StatefulSets("k8s-ns1").Get("not-opted-in", k8sMetaV1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := statefulSet.Spec.Template.Annotations[d.rolloutHashAnnotationName()]; ok {
+		t.Fatal("Workload without opt-in annotation shouldn't be rolled out")
+	}
+	daemonSet, err = d.k8sClient.AppsV1().DaemonSets("k8s-ns1").Get("other-secret", k8sMetaV1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := daemonSet.Spec.Template.Annotations[d.rolloutHashAnnotationName()]; ok {
+		t.Fatal("Workload which doesn't use secret shouldn't be rolled out")
+	}
+
+	// Workloads aren't rolled out if secret isn't changed
+	results = d.updateSecretInK8s("", secretForUpdate{name: "app", versioning: 0}, "k8s-ns1", "k8s-ns1", "."+k8sClusterName, []string{}, []string{})
+	if results.err != nil {
+		t.Fatal(results.err)
+	}
+	if results.rolledOut != 0 {
+		t.Fatalf("Workloads shouldn't be rolled out if secret isn't changed, got '%v'", results.rolledOut)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	k8sCoreV1 "k8s.io/api/core/v1"
+)
+
+// Key in 'custom_metadata' of KV v2 secret with type of k8s secret
+const secretTypeMetadataKey = "k8s-secret-type"
+
+// Short names of k8s secret types which can be used in 'custom_metadata'
+var secretTypeAliases = map[string]k8sCoreV1.SecretType{
+	"opaque":           k8sCoreV1.SecretTypeOpaque,
+	"tls":              k8sCoreV1.SecretTypeTLS,
+	"dockerconfigjson": k8sCoreV1.SecretTypeDockerConfigJson,
+	"dockercfg":        k8sCoreV1.SecretTypeDockercfg,
+	"basic-auth":       k8sCoreV1.SecretTypeBasicAuth,
+	"ssh-auth":         k8sCoreV1.SecretTypeSSHAuth,
+}
+
+// Get type of k8s secret from custom metadata of Vault secret or from names of keys in it
+func getSecretType(customMetadata map[string]string, data map[string][]byte) k8sCoreV1.SecretType {
+	if t := strings.TrimSpace(customMetadata[secretTypeMetadataKey]); t != "" {
+		return parseSecretType(t)
+	}
+
+	_, tlsCrt := data[k8sCoreV1.TLSCertKey]
+	_, tlsKey := data[k8sCoreV1.TLSPrivateKeyKey]
+	if tlsCrt && tlsKey {
+		return k8sCoreV1.SecretTypeTLS
+	}
+	if _, ok := data[k8sCoreV1.DockerConfigJsonKey]; ok {
+		return k8sCoreV1.SecretTypeDockerConfigJson
+	}
+	if _, ok := data[k8sCoreV1.SSHAuthPrivateKey]; ok {
+		return k8sCoreV1.SecretTypeSSHAuth
+	}
+
+	return k8sCoreV1.SecretTypeOpaque
+}
+
+// Get type of k8s secret by its full or short name
+func parseSecretType(t string) k8sCoreV1.SecretType {
+	if secretType, ok := secretTypeAliases[strings.ToLower(strings.TrimSpace(t))]; ok {
+		return secretType
+	}
+
+	return k8sCoreV1.SecretType(strings.TrimSpace(t))
+}
+
+// Check if data of secret has keys required by its type
+func verifySecretType(secretType k8sCoreV1.SecretType, data map[string][]byte) error {
+	switch secretType {
+	case k8sCoreV1.SecretTypeTLS:
+		for _, k := range []string{k8sCoreV1.TLSCertKey, k8sCoreV1.TLSPrivateKeyKey} {
+			if _, ok := data[k]; !ok {
+				return fmt.Errorf("key '%s' is required for '%s' secret type", k, secretType)
+			}
+		}
+	case k8sCoreV1.SecretTypeDockerConfigJson:
+		v, ok := data[k8sCoreV1.DockerConfigJsonKey]
+		if !ok {
+			return fmt.Errorf("key '%s' is required for '%s' secret type", k8sCoreV1.DockerConfigJsonKey, secretType)
+		}
+		if !json.Valid(v) {
+			return fmt.Errorf("key '%s' isn't valid JSON", k8sCoreV1.DockerConfigJsonKey)
+		}
+	case k8sCoreV1.SecretTypeDockercfg:
+		v, ok := data[k8sCoreV1.DockerConfigKey]
+		if !ok {
+			return fmt.Errorf("key '%s' is required for '%s' secret type", k8sCoreV1.DockerConfigKey, secretType)
+		}
+		if !json.Valid(v) {
+			return fmt.Errorf("key '%s' isn't valid JSON", k8sCoreV1.DockerConfigKey)
+		}
+	case k8sCoreV1.SecretTypeBasicAuth:
+		_, username := data[k8sCoreV1.BasicAuthUsernameKey]
+		_, password := data[k8sCoreV1.BasicAuthPasswordKey]
+		if !username && !password {
+			return fmt.Errorf("key '%s' or '%s' is required for '%s' secret type", k8sCoreV1.BasicAuthUsernameKey, k8sCoreV1.BasicAuthPasswordKey, secretType)
+		}
+	case k8sCoreV1.SecretTypeSSHAuth:
+		if _, ok := data[k8sCoreV1.SSHAuthPrivateKey]; !ok {
+			return fmt.Errorf("key '%s' is required for '%s' secret type", k8sCoreV1.SSHAuthPrivateKey, secretType)
+		}
+	case k8sCoreV1.SecretTypeServiceAccountToken:
+		return fmt.Errorf("secret type '%s' isn't supported", secretType)
+	}
+
+	return nil
+}
+
+// Check if types of k8s secrets are the same, empty type is 'Opaque' in k8s
+func secretTypeEqual(a, b k8sCoreV1.SecretType) bool {
+	if a == "" {
+		a = k8sCoreV1.SecretTypeOpaque
+	}
+	if b == "" {
+		b = k8sCoreV1.SecretTypeOpaque
+	}
+	return a == b
+}
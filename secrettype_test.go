@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+
+	k8sCoreV1 "k8s.io/api/core/v1"
+)
+
+// Test get type of k8s secret
+func TestGetSecretType(t *testing.T) {
+	tests := []struct {
+		customMetadata map[string]string
+		data           map[string][]byte
+		expected       k8sCoreV1.SecretType
+	}{
+		{nil, map[string][]byte{"key": []byte("value")}, k8sCoreV1.SecretTypeOpaque},
+		{nil, map[string][]byte{"tls.crt": []byte("crt"), "tls.key": []byte("key")}, k8sCoreV1.SecretTypeTLS},
+		{nil, map[string][]byte{"tls.crt": []byte("crt")}, k8sCoreV1.SecretTypeOpaque},
+		{nil, map[string][]byte{".dockerconfigjson": []byte("{}")}, k8sCoreV1.SecretTypeDockerConfigJson},
+		{nil, map[string][]byte{"ssh-privatekey": []byte("key")}, k8sCoreV1.SecretTypeSSHAuth},
+		{map[string]string{secretTypeMetadataKey: "basic-auth"}, map[string][]byte{"username": []byte("user")}, k8sCoreV1.SecretTypeBasicAuth},
+		{map[string]string{secretTypeMetadataKey: "Opaque"}, map[string][]byte{"tls.crt": []byte("crt"), "tls.key": []byte("key")}, k8sCoreV1.SecretTypeOpaque},
+		{map[string]string{secretTypeMetadataKey: "example.com/custom"}, map[string][]byte{"key": []byte("value")}, k8sCoreV1.SecretType("example.com/custom")},
+	}
+
+	for _, test := range tests {
+		if secretType := getSecretType(test.customMetadata, test.data); secretType != test.expected {
+			t.Fatalf("Incorrect type '%s' for '%v', expected '%s'", secretType, test.customMetadata, test.expected)
+		}
+	}
+}
+
+// Test verify keys of typed k8s secret
+func TestVerifySecretType(t *testing.T) {
+	tests := []struct {
+		secretType k8sCoreV1.SecretType
+		data       map[string][]byte
+		valid      bool
+	}{
+		{k8sCoreV1.SecretTypeOpaque, map[string][]byte{"key": []byte("value")}, true},
+		{k8sCoreV1.SecretTypeTLS, map[string][]byte{"tls.crt": []byte("crt"), "tls.key": []byte("key")}, true},
+		{k8sCoreV1.SecretTypeTLS, map[string][]byte{"tls.crt": []byte("crt")}, false},
+		{k8sCoreV1.SecretTypeDockerConfigJson, map[string][]byte{".dockerconfigjson": []byte(`{"auths":{}}`)}, true},
+		{k8sCoreV1.SecretTypeDockerConfigJson, map[string][]byte{".dockerconfigjson": []byte("notJSON")}, false},
+		{k8sCoreV1.SecretTypeBasicAuth, map[string][]byte{"password": []byte("pass")}, true},
+		{k8sCoreV1.SecretTypeBasicAuth, map[string][]byte{"key": []byte("value")}, false},
+		{k8sCoreV1.SecretTypeSSHAuth, map[string][]byte{"key": []byte("value")}, false},
+		{k8sCoreV1.SecretTypeServiceAccountToken, map[string][]byte{"token": []byte("value")}, false},
+	}
+
+	for _, test := range tests {
+		err := verifySecretType(test.secretType, test.data)
+		if test.valid && err != nil {
+			t.Log(err)
+			t.Fatalf("Error should not be raised for '%s' type", test.secretType)
+		}
+		if !test.valid && err == nil {
+			t.Fatalf("Error should be raised for '%s' type", test.secretType)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Context which is canceled on SIGTERM/SIGINT, application exits if shutdown takes longer than SHUTDOWN_TIMEOUT
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		select {
+		case s := <-signals:
+			glog.Infoln("Received '" + s.String() + "' signal, shutting down")
+			cancel()
+		case <-ctx.Done():
+			return
+		}
+
+		// Second signal or timeout stops application immediately
+		select {
+		case s := <-signals:
+			glog.Errorln("Received '" + s.String() + "' signal during shutdown, exiting")
+		case <-time.After(time.Second * time.Duration(shutdownTimeout)):
+			glog.Errorln("Shutdown wasn't finished during SHUTDOWN_TIMEOUT, exiting")
+		}
+		glog.Flush()
+		os.Exit(1)
+	}()
+
+	return ctx, cancel
+}
+
+// Wait for duration, returns 'false' if context was canceled before
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -17,6 +17,7 @@ import (
 
 const (
 	tvsMountPath          = "testMount"
+	tvsKV1MountPath       = "secret"
 	tvsAppRoleName        = "vault-to-k8s"
 	tvsAppRoleTokenTTL    = 3600
 	tvsAppRoleSecretIDTTL = 7200
@@ -364,3 +365,19 @@ func (d *vtkData) testVaultServerDeleteSecret(t *testing.T, secretName, secretNa
 		t.Fatal(err)
 	}
 }
+
+// Create secrets in KV version 1 mount
+func (d *vtkData) testVaultServerCreateSecretsKV1(t *testing.T, secretsList []string, secretNamespace string) {
+	t.Helper()
+
+	tvsVaultSecretsMount := strings.SplitN(vaultSecretsPath, "/", 2)[1]
+	for _, secretName := range secretsList {
+		optionsSecret := map[string]interface{}{
+			"testKey-" + secretName: "testValue-" + secretName,
+		}
+		vaultSecretPathFull := tvsKV1MountPath + "/" + tvsVaultSecretsMount + "/" + secretNamespace + "/" + secretName
+		if _, err := d.vaultClient.Logical().Write(vaultSecretPathFull, optionsSecret); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
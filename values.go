@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Strategies for non-string values of Vault secret
+const (
+	nonStringValuesSkip    = "skip"
+	nonStringValuesJSON    = "json"
+	nonStringValuesFlatten = "flatten"
+)
+
+// Convert data of Vault secret to data of k8s secret, returns 'true' if secret had non-string values
+func convertSecretData(s map[string]interface{}) (map[string][]byte, bool, error) {
+	data := make(map[string][]byte)
+	nonString := false
+
+	// Sort keys for getting the same errors on each sync
+	keys := make([]string, 0, len(s))
+	for k := range s {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if v, ok := s[k].(string); ok {
+			if err := setSecretDataKey(data, k, []byte(v)); err != nil {
+				return nil, nonString, err
+			}
+			continue
+		}
+		nonString = true
+
+		switch nonStringValues {
+		case nonStringValuesJSON:
+			v, err := json.Marshal(s[k])
+			if err != nil {
+				return nil, nonString, fmt.Errorf("can't encode value of key '%s' to JSON: %s", k, err)
+			}
+			if err := setSecretDataKey(data, k, v); err != nil {
+				return nil, nonString, err
+			}
+		case nonStringValuesFlatten:
+			if err := flattenSecretData(data, k, s[k]); err != nil {
+				return nil, nonString, err
+			}
+		default:
+			return nil, nonString, fmt.Errorf("value of key '%s' isn't a string", k)
+		}
+	}
+
+	return data, nonString, nil
+}
+
+// Flatten nested maps to keys joined by FLATTEN_SEPARATOR, other non-string values are encoded to JSON
+func flattenSecretData(data map[string][]byte, key string, value interface{}) error {
+	switch v := value.(type) {
+	case string:
+		return setSecretDataKey(data, key, []byte(v))
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if err := flattenSecretData(data, key+flattenSeparator+k, v[k]); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("can't encode value of key '%s' to JSON: %s", key, err)
+		}
+		return setSecretDataKey(data, key, encoded)
+	}
+}
+
+// Set key of k8s secret data, keys made by flatten can conflict with existing keys
+func setSecretDataKey(data map[string][]byte, key string, value []byte) error {
+	if _, ok := data[key]; ok {
+		return fmt.Errorf("key '%s' is duplicated", key)
+	}
+	data[key] = value
+
+	return nil
+}
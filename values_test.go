@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// Test convert Vault secret data with non-string values
+func TestConvertSecretData(t *testing.T) {
+	defer defineAppInitParams()
+
+	s := map[string]interface{}{
+		"key":     "value",
+		"enabled": true,
+		"port":    json.Number("8080"),
+		"db": map[string]interface{}{
+			"host":  "localhost",
+			"ports": []interface{}{"5432", "5433"},
+		},
+	}
+
+	// 'skip' strategy
+	nonStringValues = "skip"
+	if _, nonString, err := convertSecretData(s); err == nil || !nonString {
+		t.Fatal("Error should be raised for non-string values")
+	}
+	data, nonString, err := convertSecretData(map[string]interface{}{"key": "value"})
+	if err != nil || nonString {
+		t.Log(err)
+		t.Fatal("Error should not be raised for string values")
+	}
+	if string(data["key"]) != "value" {
+		t.Fatalf("Incorrect value '%s' for key 'key', expected 'value'", data["key"])
+	}
+
+	// 'json' strategy
+	nonStringValues = "json"
+	data, nonString, err = convertSecretData(s)
+	if err != nil || !nonString {
+		t.Log(err)
+		t.Fatal("Error should not be raised")
+	}
+	expected := map[string]string{
+		"key":     "value",
+		"enabled": "true",
+		"port":    "8080",
+		"db":      `{"host":"localhost","ports":["5432","5433"]}`,
+	}
+	for k, v := range expected {
+		if string(data[k]) != v {
+			t.Fatalf("Incorrect value '%s' for key '%s', expected '%s'", data[k], k, v)
+		}
+	}
+
+	// 'flatten' strategy
+	nonStringValues = "flatten"
+	flattenSeparator = "_"
+	data, nonString, err = convertSecretData(s)
+	if err != nil || !nonString {
+		t.Log(err)
+		t.Fatal("Error should not be raised")
+	}
+	expected = map[string]string{
+		"key":      "value",
+		"enabled":  "true",
+		"port":     "8080",
+		"db_host":  "localhost",
+		"db_ports": `["5432","5433"]`,
+	}
+	if len(data) != len(expected) {
+		t.Fatalf("Incorrect number of keys '%d', expected '%d'", len(data), len(expected))
+	}
+	for k, v := range expected {
+		if string(data[k]) != v {
+			t.Fatalf("Incorrect value '%s' for key '%s', expected '%s'", data[k], k, v)
+		}
+	}
+
+	// Flattened keys shouldn't overwrite existing keys
+	if _, _, err := convertSecretData(map[string]interface{}{"db_host": "value", "db": map[string]interface{}{"host": "localhost"}}); err == nil {
+		t.Fatal("Error should be raised for duplicated keys")
+	}
+}
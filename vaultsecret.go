@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	k8sCoreV1 "k8s.io/api/core/v1"
+	k8sApiErr "k8s.io/apimachinery/pkg/api/errors"
+	k8sMetaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+// Resource of VaultSecret custom resources
+var vaultSecretResource = schema.GroupVersionResource{Group: "vault-to-k8s.io", Version: "v1alpha1", Resource: "vaultsecrets"}
+
+// Statuses of VaultSecret custom resources in metrics
+const (
+	vaultSecretSynced = "synced"
+	vaultSecretFailed = "failed"
+)
+
+// VaultSecret custom resource, it declares sync of one Vault secret to k8s secret in the same namespace
+type vaultSecret struct {
+	k8sMetaV1.TypeMeta   `json:",inline"`
+	k8sMetaV1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   vaultSecretSpec   `json:"spec"`
+	Status vaultSecretStatus `json:"status,omitempty"`
+}
+
+// Desired state of VaultSecret
+type vaultSecretSpec struct {
+	Source          string           `json:"source,omitempty"`          // Name of source from configuration file
+	Path            string           `json:"path"`                      // Path to Vault secret relative to Vault directory of namespace
+	SecretName      string           `json:"secretName,omitempty"`      // Name of k8s secret, name of VaultSecret by default
+	Type            string           `json:"type,omitempty"`            // Type of k8s secret
+	Keys            []vaultSecretKey `json:"keys,omitempty"`            // Keys of Vault secret for sync, all keys by default
+	RefreshInterval int              `json:"refreshInterval,omitempty"` // How many seconds to wait between syncs
+}
+
+// Key of Vault secret and its name in k8s secret
+type vaultSecretKey struct {
+	Key  string `json:"key"`
+	Name string `json:"name,omitempty"`
+}
+
+// Observed state of VaultSecret
+type vaultSecretStatus struct {
+	ObservedGeneration int64  `json:"observedGeneration,omitempty"`
+	SecretName         string `json:"secretName,omitempty"`
+	VaultPath          string `json:"vaultPath,omitempty"`
+	SyncedVersion      string `json:"syncedVersion,omitempty"`
+	LastSyncTime       string `json:"lastSyncTime,omitempty"`
+	LastError          string `json:"lastError,omitempty"`
+}
+
+// Create a new k8s dynamic client for custom resources
+func newDynamicClient() (dynamic.Interface, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get k8s config")
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get k8s dynamic client")
+	}
+
+	return dynamicClient, nil
+}
+
+// Sync VaultSecret custom resources each VAULT_SECRETS_POLL_INTERVAL until context is canceled
+func (d *vtkData) syncVaultSecrets(ctx context.Context) {
+	ticker := time.NewTicker(time.Second * time.Duration(vaultSecretsPollInterval))
+	defer ticker.Stop()
+	for {
+		d.syncVaultSecretsCycle(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			glog.Infoln("Sync of VaultSecret resources stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync VaultSecret custom resources which should be refreshed, returns 'true' if all of them are synced successfully
+func (d *vtkData) syncVaultSecretsCycle(ctx context.Context, now time.Time) bool {
+	start := time.Now()
+	list, err := d.dynamicClient.Resource(vaultSecretResource).Namespace("").List(k8sMetaV1.ListOptions{})
+	observeAPIRequest(backendK8s, "list_vaultsecrets", start, err)
+	if err != nil {
+		glog.Errorln(errors.Wrap(err, "Error during list VaultSecret resources"))
+		return false
+	}
+	_, excludedNamespaces, err := d.k8sNamespacesList()
+	if err != nil {
+		glog.Errorln(err)
+		return false
+	}
+
+	// Vault directories of namespaces are listed once per cycle for each source
+	vaultDirs := make(map[string]map[string]string)
+	statuses := map[string]float64{
+		vaultSecretSynced: 0,
+		vaultSecretFailed: 0,
+	}
+	if dryRun == "true" && d.plannedVaultSecrets == nil {
+		d.plannedVaultSecrets = make(map[string]vaultSecretStatus)
+	}
+	listed := make(map[string]bool)
+	for i := range list.Items {
+		// Don't start sync of new resources if application is stopping
+		if ctx.Err() != nil {
+			break
+		}
+		obj := &list.Items[i]
+		vs := &vaultSecret{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, vs); err != nil {
+			glog.Errorln(errors.Wrap(err, "Error during parse VaultSecret '"+obj.GetNamespace()+"/"+obj.GetName()+"'"))
+			statuses[vaultSecretFailed]++
+			continue
+		}
+		if dryRun == "true" {
+			// Generation and time of the last plan are used instead of status
+			listed[vs.Namespace+"/"+vs.Name] = true
+			if planned, ok := d.plannedVaultSecrets[vs.Namespace+"/"+vs.Name]; ok {
+				vs.Status = planned
+			}
+		}
+		if vs.refreshRequired(now) {
+			d.syncVaultSecret(obj, vs, excludedNamespaces, vaultDirs, now)
+		}
+		if vs.Status.LastError != "" {
+			statuses[vaultSecretFailed]++
+		} else {
+			statuses[vaultSecretSynced]++
+		}
+	}
+	for status, count := range statuses {
+		vaultSecrets.WithLabelValues(status).Set(count)
+	}
+
+	// Forget plans of VaultSecret resources which were deleted
+	if ctx.Err() == nil {
+		for resource := range d.plannedVaultSecrets {
+			if !listed[resource] {
+				delete(d.plannedVaultSecrets, resource)
+			}
+		}
+	}
+
+	return statuses[vaultSecretFailed] == 0
+}
+
+// VaultSecret was changed or its refresh interval passed since the last sync
+func (vs *vaultSecret) refreshRequired(now time.Time) bool {
+	if vs.Status.ObservedGeneration != vs.Generation {
+		return true
+	}
+	lastSync, err := time.Parse(time.RFC3339, vs.Status.LastSyncTime)
+	if err != nil {
+		return true
+	}
+	refreshInterval := vs.Spec.RefreshInterval
+	if refreshInterval <= 0 {
+		refreshInterval = syncInterval
+	}
+
+	return !now.Before(lastSync.Add(time.Second * time.Duration(refreshInterval)))
+}
+
+// Sync one VaultSecret and update its status
+func (d *vtkData) syncVaultSecret(obj *unstructured.Unstructured, vs *vaultSecret, excludedNamespaces map[string]string, vaultDirs map[string]map[string]string, now time.Time) {
+	resourceName := "VaultSecret '" + vs.Namespace + "/" + vs.Name + "'"
+	status := vaultSecretStatus{
+		ObservedGeneration: vs.Generation,
+		LastSyncTime:       now.UTC().Format(time.RFC3339),
+	}
+
+	action, err := d.updateVaultSecretInK8s(vs, &status, excludedNamespaces, vaultDirs)
+	if err != nil {
+		glog.Errorln(errors.Wrap(err, "Error during sync of "+resourceName))
+		status.LastError = err.Error()
+	}
+	if dryRun == "true" {
+		if err == nil {
+			glog.Infoln("[DRY-RUN] " + resourceName + ": would-" + action + " secret '" + status.SecretName + "' from '" + status.VaultPath + "'")
+		}
+		d.plannedVaultSecrets[vs.Namespace+"/"+vs.Name] = status
+		vs.Status = status
+		return
+	}
+	if err != nil {
+		d.recordEvent(obj, k8sCoreV1.EventTypeWarning, eventSyncFailed, err.Error())
+	} else if action != actionNone {
+		glog.V(2).Infoln("Synced secret '" + status.SecretName + "' in '" + vs.Namespace + "' namespace from '" + status.VaultPath + "' for " + resourceName)
+		d.recordEvent(obj, k8sCoreV1.EventTypeNormal, eventSynced, "Secret '"+status.SecretName+"' was synced from Vault secret '"+status.VaultPath+"' version '"+status.SyncedVersion+"'")
+	}
+
+	// Update status subresource
+	statusObj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+	if err != nil {
+		glog.Errorln(errors.Wrap(err, "Error during convert status of "+resourceName))
+		return
+	}
+	obj.Object["status"] = statusObj
+	start := time.Now()
+	_, err = d.dynamicClient.Resource(vaultSecretResource).Namespace(vs.Namespace).UpdateStatus(obj, k8sMetaV1.UpdateOptions{})
+	observeAPIRequest(backendK8s, "update_vaultsecret_status", start, err)
+	if err != nil {
+		glog.Errorln(errors.Wrap(err, "Error during update status of "+resourceName))
+		return
+	}
+	vs.Status = status
+}
+
+// Create/update k8s secret for VaultSecret, returns action which was done
+func (d *vtkData) updateVaultSecretInK8s(vs *vaultSecret, status *vaultSecretStatus, excludedNamespaces map[string]string, vaultDirs map[string]map[string]string) (string, error) {
+	if reason, ok := excludedNamespaces[vs.Namespace]; ok {
+		return "", fmt.Errorf("Namespace '%s' is excluded from sync, reason: %s", vs.Namespace, reason)
+	}
+
+	// Source of Vault secret
+	var source *vtkData
+	for _, s := range d.syncSources() {
+		if vs.Spec.Source == "" || s.sourceName() == vs.Spec.Source {
+			source = s
+			break
+		}
+	}
+	if source == nil {
+		return "", fmt.Errorf("Source '%s' wasn't found in configuration file", vs.Spec.Source)
+	}
+
+	// Secrets can be read only from Vault directory which is synced to namespace of VaultSecret
+	if _, ok := vaultDirs[source.sourceName()]; !ok {
+		vaultNamespaces, err := source.vaultNamespacesList()
+		if err != nil {
+			return "", errors.Wrap(err, "Error during list Vault directories")
+		}
+		vaultDirs[source.sourceName()] = make(map[string]string)
+		for _, ns := range source.mapVaultDirs(vaultNamespaces) {
+			vaultDirs[source.sourceName()][ns.namespace] = ns.vaultDir
+		}
+	}
+	vaultDir, ok := vaultDirs[source.sourceName()][vs.Namespace]
+	if !ok {
+		return "", fmt.Errorf("There is no Vault directory under '%s' which is synced to '%s' namespace", source.vaultSecretsPath(), vs.Namespace)
+	}
+	vaultPath := strings.Trim(vs.Spec.Path, "/")
+	if vaultPath == "" {
+		return "", fmt.Errorf("Path to Vault secret isn't defined")
+	}
+	for _, part := range strings.Split(vaultPath, "/") {
+		if part == "" || part == "." || part == ".." {
+			return "", fmt.Errorf("Path '%s' to Vault secret isn't valid", vs.Spec.Path)
+		}
+	}
+	// Secrets of other clusters and from subdirectories deeper than SECRETS_MAX_DEPTH aren't synced to namespace
+	if strings.Count(vaultPath, "/") >= secretsMaxDepth {
+		return "", fmt.Errorf("Path '%s' to Vault secret is deeper than %d level(s) of 'SECRETS_MAX_DEPTH'", vs.Spec.Path, secretsMaxDepth)
+	}
+	if _, ok := source.filterSecrets([]string{vaultPath}, "."+source.k8sClusterName(), vs.Namespace)[vaultPath]; !ok {
+		return "", fmt.Errorf("Vault secret '%s' isn't synced to '%s' cluster", vs.Spec.Path, source.k8sClusterName())
+	}
+	status.VaultPath = source.vaultSecretsPath() + "/" + vaultDir + "/" + vaultPath
+
+	status.SecretName = vs.Spec.SecretName
+	if status.SecretName == "" {
+		status.SecretName = vs.Name
+	}
+	if errs := validation.IsDNS1123Subdomain(status.SecretName); errs != nil {
+		return "", fmt.Errorf("Name '%s' of k8s secret isn't valid: %s", status.SecretName, strings.Join(errs, ","))
+	}
+
+	// Read Vault secret
+	s, v, err := source.secretsRead(status.VaultPath)
+	if err != nil {
+		return "", errors.Wrap(err, "Error during read Vault secret")
+	}
+	if len(s) == 0 {
+		return "", fmt.Errorf("Vault secret '%s' doesn't exist or doesn't have data", status.VaultPath)
+	}
+	data, _, err := convertSecretData(s)
+	if err != nil {
+		return "", errors.Wrap(err, "Incorrect data in Vault secret")
+	}
+	if len(vs.Spec.Keys) != 0 {
+		selectedData := make(map[string][]byte)
+		for _, key := range vs.Spec.Keys {
+			value, ok := data[key.Key]
+			if !ok {
+				return "", fmt.Errorf("Key '%s' doesn't exist in Vault secret '%s'", key.Key, status.VaultPath)
+			}
+			name := key.Name
+			if name == "" {
+				name = key.Key
+			}
+			selectedData[name] = value
+		}
+		data = selectedData
+	}
+	secretType := parseSecretType(vs.Spec.Type)
+	if secretType != "" {
+		if err := verifySecretType(secretType, data); err != nil {
+			return "", errors.Wrap(err, "Data of Vault secret doesn't match '"+string(secretType)+"' type")
+		}
+	}
+	status.SyncedVersion = v
+
+	// K8s secret is owned by VaultSecret, so it's deleted together with VaultSecret
+	secret := &k8sCoreV1.Secret{}
+	secret.Name = status.SecretName
+	secret.Data = data
+	secret.Type = secretType
+	secret.Annotations = map[string]string{
+		source.vaultSecretAnnotationName(): vs.Name,
+		source.versionAnnotationName():     v,
+	}
+	controller := true
+	secret.OwnerReferences = []k8sMetaV1.OwnerReference{{
+		APIVersion: vaultSecretResource.GroupVersion().String(),
+		Kind:       "VaultSecret",
+		Name:       vs.Name,
+		UID:        vs.UID,
+		Controller: &controller,
+	}}
+
+	start := time.Now()
+	existing, err := d.k8sClient.CoreV1().Secrets(vs.Namespace).Get(secret.Name, k8sMetaV1.GetOptions{})
+	observeAPIRequest(backendK8s, "get_secret", start, err)
+	if k8sApiErr.IsNotFound(err) {
+		if dryRun == "true" {
+			return actionCreate, nil
+		}
+		start := time.Now()
+		_, err := d.k8sClient.CoreV1().Secrets(vs.Namespace).Create(secret)
+		observeAPIRequest(backendK8s, "create_secret", start, err)
+		if err != nil {
+			return "", errors.Wrap(err, "Error during create k8s secret")
+		}
+		return actionCreate, nil
+	} else if err != nil {
+		return "", errors.Wrap(err, "Error during get k8s secret")
+	}
+
+	// Secret which wasn't created for this VaultSecret is never overwritten
+	if existing.Annotations[source.vaultSecretAnnotationName()] != vs.Name {
+		return "", fmt.Errorf("K8s secret '%s' already exists and isn't managed by this VaultSecret (annotation '%s' is missing or different)", secret.Name, source.vaultSecretAnnotationName())
+	}
+	if reflect.DeepEqual(existing.Data, secret.Data) && secretTypeEqual(existing.Type, secret.Type) && existing.Annotations[source.versionAnnotationName()] == v {
+		return actionNone, nil
+	}
+	if dryRun == "true" {
+		return actionUpdate, nil
+	}
+	if !secretTypeEqual(existing.Type, secret.Type) {
+		// Type of k8s secret is immutable, therefore recreate secret
+		start := time.Now()
+		err := d.k8sClient.CoreV1().Secrets(vs.Namespace).Delete(secret.Name, &k8sMetaV1.DeleteOptions{})
+		observeAPIRequest(backendK8s, "delete_secret", start, err)
+		if err != nil {
+			return "", errors.Wrap(err, "Error during delete k8s secret")
+		}
+		start = time.Now()
+		_, err = d.k8sClient.CoreV1().Secrets(vs.Namespace).Create(secret)
+		observeAPIRequest(backendK8s, "create_secret", start, err)
+		if err != nil {
+			return "", errors.Wrap(err, "Error during recreate k8s secret")
+		}
+		return actionRecreate, nil
+	}
+	secret.ResourceVersion = existing.ResourceVersion
+	start = time.Now()
+	_, err = d.k8sClient.CoreV1().Secrets(vs.Namespace).Update(secret)
+	observeAPIRequest(backendK8s, "update_secret", start, err)
+	if err != nil {
+		return "", errors.Wrap(err, "Error during update k8s secret")
+	}
+
+	return actionUpdate, nil
+}
+
+// Annotation with name of VaultSecret which manages k8s secret
+func (d *vtkData) vaultSecretAnnotationName() string {
+	return d.annotationName() + "-vaultsecret"
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	k8sCoreV1 "k8s.io/api/core/v1"
+	k8sMetaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicFake "k8s.io/client-go/dynamic/fake"
+)
+
+// Make VaultSecret custom resource
+func testVaultSecret(t *testing.T, name, namespace string, spec vaultSecretSpec) runtime.Object {
+	t.Helper()
+
+	vs := &vaultSecret{Spec: spec}
+	vs.APIVersion = vaultSecretResource.GroupVersion().String()
+	vs.Kind = "VaultSecret"
+	vs.Name = name
+	vs.Namespace = namespace
+	vs.Generation = 1
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(vs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &unstructured.Unstructured{Object: obj}
+}
+
+// Read status of VaultSecret custom resource
+func (d *vtkData) testVaultSecretStatus(t *testing.T, name, namespace string) vaultSecretStatus {
+	t.Helper()
+
+	obj, err := d.dynamicClient.Resource(vaultSecretResource).Namespace(namespace).Get(name, k8sMetaV1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vs := &vaultSecret{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, vs); err != nil {
+		t.Fatal(err)
+	}
+
+	return vs.Status
+}
+
+// Test sync of VaultSecret resources, errors in their status and refresh after refresh interval
+func TestSyncVaultSecretsCycle(t *testing.T) {
+	d := &vtkData{}
+	tvsd := d.testVaultServer(t)
+	defer tvsd.server.Close()
+	_ = d.testK8sServer(t)
+	defer defineAppInitParams()
+	syncInterval = 60
+	d.deniedNamespaces = map[string]bool{"k8s-ns2": true}
+	for _, name := range []string{"k8s-ns1", "k8s-ns2"} {
+		namespace := &k8sCoreV1.Namespace{}
+		namespace.Name = name
+		if _, err := d.k8sClient.CoreV1().Namespaces().Create(namespace); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.testK8sServerCreateSecret(t, "unmanaged", "k8s-ns1", "some-annotation", "")
+	d.testVaultServerCreateSecrets(t, []string{"db/primary"}, "k8s-ns1")
+	tvsMountPath := strings.SplitN(vaultSecretsPath, "/", 2)[0] + "/data/" + strings.SplitN(vaultSecretsPath, "/", 2)[1]
+	if _, err := d.vaultClient.Logical().Write(tvsMountPath+"/k8s-ns1/tls", map[string]interface{}{"data": map[string]interface{}{"tls.crt": "testCrt", "tls.key": "testKey"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	d.dynamicClient = dynamicFake.NewSimpleDynamicClient(runtime.NewScheme(),
+		testVaultSecret(t, "app", "k8s-ns1", vaultSecretSpec{Path: "secret1", Keys: []vaultSecretKey{{Key: "testKey-secret1", Name: "password"}}}),
+		testVaultSecret(t, "escape", "k8s-ns1", vaultSecretSpec{Path: "../k8s-ns2/secret10"}),
+		testVaultSecret(t, "missing-key", "k8s-ns1", vaultSecretSpec{Path: "secret1", Keys: []vaultSecretKey{{Key: "missing"}}}),
+		testVaultSecret(t, "unmanaged", "k8s-ns1", vaultSecretSpec{Path: "secret6"}),
+		testVaultSecret(t, "denied", "k8s-ns2", vaultSecretSpec{Path: "secret10"}),
+		testVaultSecret(t, "other-cluster", "k8s-ns1", vaultSecretSpec{Path: tvsd.secretsList[2]}),
+		testVaultSecret(t, "too-deep", "k8s-ns1", vaultSecretSpec{Path: "db/primary"}),
+		testVaultSecret(t, "tls", "k8s-ns1", vaultSecretSpec{Path: "tls", Type: "tls"}),
+	)
+
+	now := time.Now()
+	if d.syncVaultSecretsCycle(context.Background(), now) {
+		t.Fatal("Sync should be unsuccessful as some VaultSecret resources are incorrect")
+	}
+
+	// Secret is created with selected keys and owned by VaultSecret
+	secret, err := d.testK8sServerReadTestSecret(t, "app", "k8s-ns1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secret.Data) != 1 || string(secret.Data["password"]) != "testValue-secret1" {
+		t.Fatalf("Incorrect data of secret 'app': '%v'", secret.Data)
+	}
+	if len(secret.OwnerReferences) != 1 || secret.OwnerReferences[0].Kind != "VaultSecret" || secret.OwnerReferences[0].Name != "app" {
+		t.Fatalf("Incorrect owner of secret 'app': '%v'", secret.OwnerReferences)
+	}
+	status := d.testVaultSecretStatus(t, "app", "k8s-ns1")
+	if status.LastError != "" || status.SyncedVersion != "2" || status.VaultPath != vaultSecretsPath+"/k8s-ns1/secret1" || status.ObservedGeneration != 1 {
+		t.Fatalf("Incorrect status of VaultSecret 'app': '%+v'", status)
+	}
+
+	// Short name of type is resolved as in custom metadata
+	secret, err = d.testK8sServerReadTestSecret(t, "tls", "k8s-ns1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Type != k8sCoreV1.SecretTypeTLS {
+		t.Fatalf("Incorrect type '%s' of secret 'tls', expected '%s'", secret.Type, k8sCoreV1.SecretTypeTLS)
+	}
+
+	// Errors are reported in status, secrets of other clusters and deeper than SECRETS_MAX_DEPTH aren't synced
+	for name, namespace := range map[string]string{"escape": "k8s-ns1", "missing-key": "k8s-ns1", "unmanaged": "k8s-ns1", "denied": "k8s-ns2", "other-cluster": "k8s-ns1", "too-deep": "k8s-ns1"} {
+		if status := d.testVaultSecretStatus(t, name, namespace); status.LastError == "" {
+			t.Fatalf("Expected error in status of VaultSecret '%s'", name)
+		}
+	}
+	for name, namespace := range map[string]string{"denied": "k8s-ns2", "other-cluster": "k8s-ns1", "too-deep": "k8s-ns1"} {
+		if _, err := d.k8sClient.CoreV1().Secrets(namespace).Get(name, k8sMetaV1.GetOptions{}); err == nil {
+			t.Fatalf("Secret '%s' shouldn't be created in '%s' namespace", name, namespace)
+		}
+	}
+	unmanaged, err := d.testK8sServerReadTestSecret(t, "unmanaged", "k8s-ns1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := unmanaged.Data["testKey-secret6"]; ok {
+		t.Fatal("Secret which isn't managed by VaultSecret shouldn't be overwritten")
+	}
+	if synced := readMetricValue(vaultSecrets.WithLabelValues(vaultSecretSynced)); synced != 2 {
+		t.Fatalf("Expected 2 synced VaultSecrets, got '%v'", synced)
+	}
+	if failed := readMetricValue(vaultSecrets.WithLabelValues(vaultSecretFailed)); failed != 6 {
+		t.Fatalf("Expected 6 failed VaultSecrets, got '%v'", failed)
+	}
+
+	// Secret is refreshed only after refresh interval
+	d.testVaultServerCreateSecrets(t, []string{"secret1"}, "k8s-ns1")
+	d.syncVaultSecretsCycle(context.Background(), now.Add(time.Second))
+	if status := d.testVaultSecretStatus(t, "app", "k8s-ns1"); status.SyncedVersion != "2" {
+		t.Fatalf("VaultSecret 'app' shouldn't be refreshed before refresh interval, got version '%s'", status.SyncedVersion)
+	}
+	d.syncVaultSecretsCycle(context.Background(), now.Add(time.Second*time.Duration(syncInterval+1)))
+	if status := d.testVaultSecretStatus(t, "app", "k8s-ns1"); status.SyncedVersion != "3" {
+		t.Fatalf("VaultSecret 'app' should be refreshed after refresh interval, got version '%s'", status.SyncedVersion)
+	}
+	secret, err = d.testK8sServerReadTestSecret(t, "app", "k8s-ns1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Annotations[d.versionAnnotationName()] != "3" {
+		t.Fatalf("Secret 'app' should be updated to version '3', got '%v'", secret.Annotations)
+	}
+}
+
+// Test VaultSecret in dry-run is planned again only after its refresh interval and its plan is forgotten after delete
+func TestSyncVaultSecretsCycleDryRun(t *testing.T) {
+	d := &vtkData{}
+	tvsd := d.testVaultServer(t)
+	defer tvsd.server.Close()
+	_ = d.testK8sServer(t)
+	defer defineAppInitParams()
+	syncInterval = 60
+	dryRun = "true"
+	namespace := &k8sCoreV1.Namespace{}
+	namespace.Name = "k8s-ns1"
+	if _, err := d.k8sClient.CoreV1().Namespaces().Create(namespace); err != nil {
+		t.Fatal(err)
+	}
+	d.dynamicClient = dynamicFake.NewSimpleDynamicClient(runtime.NewScheme(),
+		testVaultSecret(t, "app", "k8s-ns1", vaultSecretSpec{Path: "secret1"}),
+	)
+
+	now := time.Now()
+	if !d.syncVaultSecretsCycle(context.Background(), now) {
+		t.Fatal("Sync should be successful")
+	}
+	if _, err := d.k8sClient.CoreV1().Secrets("k8s-ns1").Get("app", k8sMetaV1.GetOptions{}); err == nil {
+		t.Fatal("Secret 'app' shouldn't be created in dry-run")
+	}
+	if status := d.testVaultSecretStatus(t, "app", "k8s-ns1"); status.ObservedGeneration != 0 {
+		t.Fatalf("Status of VaultSecret 'app' shouldn't be updated in dry-run, got '%+v'", status)
+	}
+	planned := d.plannedVaultSecrets["k8s-ns1/app"]
+	if planned.ObservedGeneration != 1 || planned.LastSyncTime != now.UTC().Format(time.RFC3339) {
+		t.Fatalf("Incorrect plan of VaultSecret 'app': '%+v'", planned)
+	}
+
+	// Plan isn't repeated before refresh interval
+	d.syncVaultSecretsCycle(context.Background(), now.Add(time.Second*2))
+	if lastSync := d.plannedVaultSecrets["k8s-ns1/app"].LastSyncTime; lastSync != planned.LastSyncTime {
+		t.Fatalf("VaultSecret 'app' shouldn't be planned again before refresh interval, got time '%s'", lastSync)
+	}
+	refreshTime := now.Add(time.Second * time.Duration(syncInterval+1))
+	d.syncVaultSecretsCycle(context.Background(), refreshTime)
+	if lastSync := d.plannedVaultSecrets["k8s-ns1/app"].LastSyncTime; lastSync != refreshTime.UTC().Format(time.RFC3339) {
+		t.Fatalf("VaultSecret 'app' should be planned again after refresh interval, got time '%s'", lastSync)
+	}
+
+	// Plan of deleted VaultSecret is forgotten
+	if err := d.dynamicClient.Resource(vaultSecretResource).Namespace("k8s-ns1").Delete("app", &k8sMetaV1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	d.syncVaultSecretsCycle(context.Background(), refreshTime)
+	if _, ok := d.plannedVaultSecrets["k8s-ns1/app"]; ok {
+		t.Fatal("Plan of deleted VaultSecret 'app' should be forgotten")
+	}
+}